@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"../../lib"
+)
+
+func main() {
+	dir := flag.String("cache", lib.DefaultCacheDir(), "cache directory")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: cache [-cache dir] inspect|clear [git repo]")
+		os.Exit(1)
+	}
+	cache := &lib.Cache{Dir: *dir}
+	switch flag.Arg(0) {
+	case "inspect":
+		var entries []*lib.CacheEntry
+		if flag.NArg() > 1 {
+			e, err := cache.Entry(flag.Arg(1))
+			if os.IsNotExist(err) {
+				log.Fatalf("%v is not cached", flag.Arg(1))
+			} else if err != nil {
+				log.Fatal(err)
+			}
+			entries = append(entries, e)
+		} else {
+			var err error
+			if entries, err = cache.Entries(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		for _, e := range entries {
+			fmt.Printf("%v\thead %v\t%v commits\t%v bytes\tupdated %v\n", e.Repository,
+				e.Head, e.Commits, e.Size, e.Updated.Format("2006-01-02 15:04:05"))
+		}
+	case "clear":
+		if err := cache.Clear(flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown command %q", flag.Arg(0))
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"../../lib"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: classify train|flag [flags] [git repo] [issues file]")
+	fmt.Fprintln(os.Stderr, "  train learns the kinds of the issues of the labeled file (-l)")
+	fmt.Fprintln(os.Stderr, "  flag writes the issues whose kind differs from the one learned, for stats -corrected")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var opts lib.SourceFlags
+	repository := flag.String("r", "ofbiz", "repository")
+	model := flag.String("m", "", "model file (default <cache>/models/<repository>.json)")
+	labeled := flag.String("l", "", "labeled issues file (Key, Kind, Title, Description) to train on")
+	minimum := flag.Float64("p", 0.8, "minimum probability of the kind learned to flag an issue")
+	out := flag.String("o", "", "file to write the flagged issues to (default standard output)")
+	opts.RegisterFlags(flag.CommandLine)
+	flag.Usage = usage
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+	command := os.Args[1]
+	flag.CommandLine.Parse(os.Args[2:])
+	opts.SetArgs(flag.Args())
+	opts.Corrections = ""
+	if *model == "" {
+		*model = filepath.Join(opts.CacheDir, "models", *repository+".json")
+	}
+	if opts.IssuesFile == "" {
+		log.Fatal(lib.ErrNoIssuesFile)
+	}
+	issues, err := lib.LoadJiraWorkItems(opts.IssuesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, err := opts.Config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	commits, err := issueCommits(*repository, opts.Options, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch command {
+	case "train":
+		if *labeled == "" {
+			log.Fatal("missing labeled issues file (-l)")
+		}
+		nb, err := train(*labeled, issues, commits)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := nb.Save(*model); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("trained on", nb.Docs, "saved to", *model)
+	case "flag":
+		nb, err := lib.LoadNaiveBayes(*model)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := os.Stdout
+		if *out != "" {
+			if w, err = os.Create(*out); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := flagIssues(w, nb, *minimum, issues, commits); err != nil {
+			log.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
+
+// issueCommits groups the commits of the repository by issue.
+func issueCommits(repository string, opts lib.Options, config lib.Config) (map[string]*lib.Group, error) {
+	system, err := lib.LookupSystem(repository)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := system.Source(opts, config).Commits()
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+	analyzer := lib.NewAnalyzer(system.Layers)
+	for {
+		c, err := commits.Read()
+		if err == io.EOF {
+			return analyzer.Issues(), nil
+		} else if err != nil {
+			return nil, err
+		}
+		analyzer.Add(c)
+	}
+}
+
+func train(file string, issues *lib.WorkItemGraph, commits map[string]*lib.Group) (*lib.NaiveBayes, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := lib.NewTableReader(f, lib.LabeledIssuesSchema)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	nb := lib.NewNaiveBayes()
+	for {
+		row, err := t.Read()
+		if err == io.EOF {
+			return nb, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+		key, title, description := row.Get("Key"), row.Get("Title"), row.Get("Description")
+		if item := issues.Item(key); item != nil {
+			if title == "" {
+				title = item.Title
+			}
+			if description == "" {
+				description = item.Description
+			}
+		}
+		nb.Train(row.Get("Kind"), lib.IssueFeatures(title, description, commits[key]))
+	}
+}
+
+func flagIssues(out io.Writer, nb *lib.NaiveBayes, minimum float64, issues *lib.WorkItemGraph,
+	commits map[string]*lib.Group) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"Key", "Type", "Corrected Type", "Probability"})
+	flagged := 0
+	for _, id := range issues.Ids() {
+		item := issues.Item(id)
+		kind, p := nb.Classify(lib.IssueFeatures(item.Title, item.Description, commits[id]))
+		if kind != "" && p >= minimum && !strings.EqualFold(kind, item.Kind) {
+			w.Write([]string{id, item.Kind, kind, fmt.Sprintf("%.3f", p)})
+			flagged++
+		}
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "classify: %v of %v issues flagged\n", flagged, issues.Len())
+	return w.Error()
+}
@@ -3,66 +3,86 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"os"
-	"sort"
 	"time"
 
 	"../../lib"
 )
 
-type nameCount struct {
-	name  string
-	count int
-}
-
-type byCount []nameCount
-
-func (arr byCount) Len() int { return len(arr) }
-func (arr byCount) Less(i, j int) bool {
-	return arr[i].count < arr[j].count
-}
-func (arr byCount) Swap(i, j int) { arr[i], arr[j] = arr[j], arr[i] }
-
-type byModifiedTime []*lib.Commit
-
-func (arr byModifiedTime) Len() int { return len(arr) }
-func (arr byModifiedTime) Less(i, j int) bool {
-	return arr[i].Change.ModifiedTime.Before(arr[j].Change.ModifiedTime)
-}
-func (arr byModifiedTime) Swap(i, j int) { arr[i], arr[j] = arr[j], arr[i] }
-
 func main() {
+	var opts lib.SourceFlags
 	system := flag.String("s", "siop", "system")
+	window := flag.Int("w", 0, "sort window in commits (0 sorts the whole history in memory)")
+	gap := flag.Duration("gap", 0, "maximum gap between the commits of a change session "+
+		"by the same author on the same issue (0 does not group commits into sessions)")
+	out := flag.String("o", "", "directory to write the interval and activity tables and charts to")
+	tz := flag.String("work-tz", "", "time zone of the working hours and activity heatmap "+
+		"(empty uses the zone each commit was recorded in)")
+	hours := flag.String("hours", "9-18", "working hours")
+	days := flag.String("days", "Mon-Fri", "working days")
+	holidays := flag.String("holidays", "", "file of holidays, one 2006-01-02 date per line")
+	opts.RegisterFlags(flag.CommandLine)
 	flag.Parse()
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "usage [-s system] repository")
+	opts.SetArgs(flag.Args())
+	s, err := lib.LookupSystem(*system)
+	if err != nil {
+		log.Fatal(err)
+	}
+	calendar := lib.DefaultCalendar
+	if *tz != "" {
+		if calendar.Location, err = time.LoadLocation(*tz); err != nil {
+			log.Fatal(err)
+		}
 	}
-	f := lib.CommitsFunctions(*system)
-	commits, err := f.Commits(os.Args, f.IssueExtractor)
+	if calendar.Start, calendar.End, err = lib.ParseHours(*hours); err != nil {
+		log.Fatal(err)
+	}
+	if calendar.WorkingDays, err = lib.ParseWeekdays(*days); err != nil {
+		log.Fatal(err)
+	}
+	if *holidays != "" {
+		if calendar.Holidays, err = lib.LoadHolidays(*holidays); err != nil {
+			log.Fatal(err)
+		}
+	}
+	config, err := opts.Config()
 	if err != nil {
 		log.Fatal(err)
 	}
-	sort.Sort(byModifiedTime(commits))
-	sum := 0
-	totalIntervals := 0.0
-	commiters := map[string]int{}
-	var lastTime *time.Time
-	for _, c := range commits {
-		sum += len(c.Files)
-		if lastTime != nil {
-			totalIntervals += c.Change.ModifiedTime.Sub(*lastTime).Hours()
+	source, err := s.Source(opts.Options, config).Commits()
+	if err != nil {
+		log.Fatal(err)
+	}
+	commits := lib.SortCommits(source, *window)
+	defer commits.Close()
+	analyzer := lib.NewActivityAnalyzer()
+	analyzer.SessionGap = *gap
+	intervals := lib.NewIntervalAnalyzer(calendar)
+	for {
+		c, err := commits.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatal(err)
 		}
-		lastTime = &c.Change.ModifiedTime
-		commiters[c.Change.Author]++
+		if err := analyzer.Add(c); err != nil {
+			log.Fatal(err)
+		}
+		intervals.Add(c)
+	}
+	activity := analyzer.Activity()
+	fmt.Println(activity.FilesPerCommit, activity.HoursBetweenCommits)
+	if activity.Sessions > 0 {
+		fmt.Println(activity.Sessions, activity.CommitsPerSession, activity.FilesPerSession,
+			activity.HoursPerSession, activity.HoursBetweenSessions)
 	}
-	authors := make([]nameCount, 0, len(commiters))
-	for k, v := range commiters {
-		authors = append(authors, nameCount{k, v})
+	for _, k := range activity.Authors {
+		fmt.Println(k.Count, k.Name)
 	}
-	sort.Sort(byCount(authors))
-	fmt.Println(float64(sum)/float64(len(commits)), float64(totalIntervals)/float64(len(commits)-1))
-	for _, k := range authors {
-		fmt.Println(k.count, k.name)
+	if *out != "" {
+		if err := writeReport(*out, intervals); err != nil {
+			log.Fatal(err)
+		}
 	}
 }
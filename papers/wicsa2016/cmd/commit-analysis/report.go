@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"../../lib"
+)
+
+// intervalBuckets are the upper bounds, in hours, of the histogram of
+// intervals.
+var intervalBuckets = []struct {
+	label string
+	hours float64
+}{{"<1h", 1}, {"1-4h", 4}, {"4-8h", 8}, {"8-24h", 24}, {"1-3d", 72}, {"3-7d", 168},
+	{"1-4w", 672}, {">4w", math.Inf(1)}}
+
+// writeReport writes the tables and charts of intervals and activity to dir.
+func writeReport(dir string, a *lib.IntervalAnalyzer) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"authors.tsv", func(w io.Writer) error { return writeIntervals(w, "author", a.Authors()) }},
+		{"issues.tsv", func(w io.Writer) error { return writeIntervals(w, "issue", a.Issues()) }},
+		{"heatmap.tsv", func(w io.Writer) error { return writeHeatmap(w, a.Heatmap) }},
+		{"heatmap.svg", func(w io.Writer) error { return heatmapSVG(w, a.Heatmap) }},
+		{"intervals.svg", func(w io.Writer) error {
+			return histogramSVG(w, map[string]*lib.Intervals{"authors": pool(a.Authors()),
+				"issues": pool(a.Issues())})
+		}},
+	}
+	for _, file := range files {
+		f, err := os.Create(filepath.Join(dir, file.name))
+		if err != nil {
+			return err
+		}
+		err = file.write(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(intervals map[string]*lib.Intervals) []string {
+	keys := make([]string, 0, len(intervals))
+	for k := range intervals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func pool(intervals map[string]*lib.Intervals) *lib.Intervals {
+	all := &lib.Intervals{}
+	for _, k := range sortedKeys(intervals) {
+		all.Wall = append(all.Wall, intervals[k].Wall...)
+		all.Working = append(all.Working, intervals[k].Working...)
+	}
+	return all
+}
+
+func writeIntervals(w io.Writer, key string, intervals map[string]*lib.Intervals) error {
+	fmt.Fprintf(w, "%v\tintervals\twall mean\twall median\twall p90\t"+
+		"working mean\tworking median\tworking p90\n", key)
+	for _, k := range sortedKeys(intervals) {
+		if err := writeSummary(w, k, intervals[k]); err != nil {
+			return err
+		}
+	}
+	return writeSummary(w, "(all)", pool(intervals))
+}
+
+// writeSummary writes the row of name, with "-" for the statistics of the
+// authors and issues of a single commit, which have no intervals.
+func writeSummary(w io.Writer, name string, i *lib.Intervals) error {
+	wall, working := lib.Summarize(i.Wall), lib.Summarize(i.Working)
+	_, err := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", name, wall.N,
+		hours(wall.Mean), hours(wall.Median), hours(wall.P90), hours(working.Mean),
+		hours(working.Median), hours(working.P90))
+	return err
+}
+
+func hours(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+func writeHeatmap(w io.Writer, heatmap [7][24]int) error {
+	fmt.Fprint(w, "weekday")
+	for h := 0; h < 24; h++ {
+		fmt.Fprintf(w, "\t%02d", h)
+	}
+	fmt.Fprintln(w)
+	for d := range heatmap {
+		fmt.Fprint(w, time.Weekday(d).String()[:3])
+		for _, n := range heatmap[d] {
+			fmt.Fprintf(w, "\t%v", n)
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func heatmapSVG(w io.Writer, heatmap [7][24]int) error {
+	const cell, left, top = 24, 40, 20
+	max := 0
+	for _, row := range heatmap {
+		for _, n := range row {
+			if n > max {
+				max = n
+			}
+		}
+	}
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%v" height="%v" `+
+		`font-family="sans-serif" font-size="10">`+"\n", left+24*cell, top+7*cell)
+	for h := 0; h < 24; h++ {
+		fmt.Fprintf(w, `<text x="%v" y="%v" text-anchor="middle">%02d</text>`+"\n",
+			left+h*cell+cell/2, top-6, h)
+	}
+	for d, row := range heatmap {
+		y := top + d*cell
+		fmt.Fprintf(w, `<text x="%v" y="%v" text-anchor="end">%v</text>`+"\n",
+			left-6, y+cell/2+4, time.Weekday(d).String()[:3])
+		for h, n := range row {
+			opacity := 0.0
+			if max > 0 {
+				opacity = float64(n) / float64(max)
+			}
+			fmt.Fprintf(w, `<rect x="%v" y="%v" width="%v" height="%v" fill="#08519c" `+
+				`fill-opacity="%.3f" stroke="#ddd"><title>%v %02d:00 %v</title></rect>`+"\n",
+				left+h*cell, y, cell, cell, opacity, time.Weekday(d).String()[:3], h, n)
+		}
+	}
+	_, err := fmt.Fprintln(w, "</svg>")
+	return err
+}
+
+func histogram(values []float64) []int {
+	counts := make([]int, len(intervalBuckets))
+	for _, v := range values {
+		for i, b := range intervalBuckets {
+			if v < b.hours {
+				counts[i]++
+				break
+			}
+		}
+	}
+	return counts
+}
+
+// histogramSVG charts the share of the wall clock and working time intervals
+// in each bucket, one panel per series.
+func histogramSVG(w io.Writer, series map[string]*lib.Intervals) error {
+	const bar, panel, left, top, height = 14, 60, 40, 20, 150
+	names := sortedKeys(series)
+	width := left + len(names)*len(intervalBuckets)*panel
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%v" height="%v" `+
+		`font-family="sans-serif" font-size="10">`+"\n", width, top+height+40)
+	fmt.Fprintf(w, `<text x="%v" y="%v" fill="#999">wall</text>`+
+		`<text x="%v" y="%v" fill="#08519c">working</text>`+"\n", left, top-6, left+40, top-6)
+	for s, name := range names {
+		x0 := left + s*len(intervalBuckets)*panel
+		fmt.Fprintf(w, `<text x="%v" y="%v" text-anchor="middle">%v</text>`+"\n",
+			x0+len(intervalBuckets)*panel/2, top+height+34, html.EscapeString(name))
+		for j, values := range [][]float64{series[name].Wall, series[name].Working} {
+			color := []string{"#999", "#08519c"}[j]
+			for i, n := range histogram(values) {
+				share := 0.0
+				if len(values) > 0 {
+					share = float64(n) / float64(len(values))
+				}
+				h := int(share * height)
+				x := x0 + i*panel + 10 + j*bar
+				fmt.Fprintf(w, `<rect x="%v" y="%v" width="%v" height="%v" fill="%v">`+
+					`<title>%v %v %.1f%%</title></rect>`+"\n",
+					x, top+height-h, bar, h, color, name,
+					html.EscapeString(intervalBuckets[i].label), share*100)
+			}
+		}
+		for i, b := range intervalBuckets {
+			fmt.Fprintf(w, `<text x="%v" y="%v" text-anchor="middle">%v</text>`+"\n",
+				x0+i*panel+10+bar, top+height+14, html.EscapeString(b.label))
+		}
+	}
+	_, err := fmt.Fprintln(w, "</svg>")
+	return err
+}
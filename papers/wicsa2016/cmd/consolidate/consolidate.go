@@ -1,206 +1,235 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"time"
 
-	"../../structs"
+	"../../lib"
 )
 
 func main() {
-	folder := open(os.Args[1])
+	workers := flag.Int("P", runtime.NumCPU(), "number of parallel lscm invocations")
+	verbose := flag.Bool("v", false, "report progress on stderr")
+	zone := flag.String("zone", lib.DefaultZone, "time zone of the change set and CSV timestamps without one")
+	dayFirst := flag.Bool("dmy", false, "read numeric dates as day/month/year")
+	monthFirst := flag.Bool("mdy", false, "read numeric dates as month/day/year")
+	tolerance := flag.Duration("t", 2*time.Minute, "time tolerance of fuzzy change set matching")
+	minScore := flag.Float64("s", 0.6, "minimum score of fuzzy change set matching")
+	report := flag.String("report", "", "file to write the change set match report to")
+	columns := flag.String("columns", "", "JSON file with aliases of the export column names")
+	var runnerFlags lib.RunnerFlags
+	runnerFlags.Register(flag.CommandLine)
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: consolidate [-P workers] [-v] [-record|-replay dir] <changesets dir>")
+		os.Exit(1)
+	}
+	dir := flag.Arg(0)
+	runner := runnerFlags.Runner()
+	loc, err := time.LoadLocation(*zone)
+	if err != nil {
+		log.Fatal(err)
+	}
+	parser := lib.TimestampParser{Location: loc}
+	if *dayFirst {
+		parser.Order = lib.DayFirst
+	} else if *monthFirst {
+		parser.Order = lib.MonthFirst
+	}
+	folder := open(dir)
 	defer folder.Close()
 	fileNames, err := folder.Readdirnames(0)
 	if err != nil {
-		log.Fatal("Error reading file names from ", os.Args[1], err)
-	}
-	changesets := map[string]*structs.Change{}
-	changesetsByUuid := map[string]*structs.Change{}
-	months := map[string]string{"jan": "01", "fev": "02", "mar": "03", "abr": "04", "mai": "05",
-		"jun": "06", "jul": "07", "ago": "08", "set": "09", "out": "10", "nov": "11", "dez": "12"}
-	monthsInEnglish := map[string]string{"jan": "January", "fev": "February", "mar": "March",
-		"abr": "April", "mai": "May", "jun": "June", "jul": "July", "ago": "August",
-		"set": "September", "out": "October", "nov": "November", "dez": "December"}
-	hours := map[string]string{"01": "13", "02": "14", "03": "15", "04": "16", "05": "17",
-		"06": "18", "07": "19", "08": "20", "09": "21", "10": "22", "11": "23", "12": "12"}
+		log.Fatal("Error reading file names from ", dir, err)
+	}
+	m := newMatcher(parser, *tolerance, *minScore)
+	filesByUuid := map[string][]string{}
 	for _, fileName := range fileNames {
 		if !strings.HasSuffix(fileName, ".json") || strings.HasSuffix(fileName, "commits.json") {
 			continue
 		}
-		j := open(filepath.Join(os.Args[1], fileName))
-		cc := &structs.Changeset{}
+		j := open(filepath.Join(dir, fileName))
+		cc := &lib.Changeset{}
 		err = json.NewDecoder(j).Decode(&cc)
+		j.Close()
 		if err != nil {
 			log.Fatal("Error decoding file ", fileName, " ", err)
 		}
+		fileMonth, monthKnown := changesetsMonth(fileName)
 		for _, c := range cc.Changes {
-			arr0 := strings.Split(c.Modified, " ")
-			arr1 := strings.Split(arr0[0], "-")
-			arr2 := strings.Split(arr0[1], ":")
-			if !strings.HasSuffix(fileName, arr1[1]+".json") &&
-				!strings.HasSuffix(fileName, monthsInEnglish[arr1[1]]+".json") {
-				continue
-			}
-			if arr0[2] == "PM" {
-				arr2[0] = hours[arr2[0]]
-			}
-			if arr0[2] == "AM" && arr2[0] == "12" {
-				arr2[0] = "00"
-			}
-			t := fmt.Sprintf("%v:%v", arr2[0], arr2[1])
-			modified := fmt.Sprintf("%v/%v/%v %v", arr1[0], months[arr1[1]], arr1[2], t)
-			comm := c.Comment
-			if len(comm) > 56 {
-				comm = comm[:56]
+			t, err := parser.Parse(c.Modified)
+			if err != nil {
+				log.Fatalf("Error in change set %v of %v: %v", c.Uuid, fileName, err)
 			}
-			if strings.ToLower(comm) == "<nenhum comentário>" {
-				comm = ""
+			if monthKnown && t.In(parser.Location).Month() != fileMonth {
+				continue
 			}
-			key := strings.ToLower(fmt.Sprintf("%v - %v - %v", comm, c.Author, modified))
-			if _, ok := changesets[key]; ok {
-				change := changesets[key]
-				change.Uuids = append(change.Uuids, c.Uuid)
-				changesetsByUuid[change.Uuid] = change
-			} else {
-				change := &structs.Change{
-					Author:   c.Author,
-					Comment:  comm,
-					Modified: modified,
-					Uuids:    []string{c.Uuid}}
-				changesets[key] = change
-				changesetsByUuid[c.Uuid] = change
+			if c.Changes != nil {
+				files := make([]string, 0, len(c.Changes))
+				for _, f := range c.Changes {
+					files = append(files, f.Path)
+				}
+				filesByUuid[c.Uuid] = files
 			}
+			m.add(c, t)
+		}
+	}
+	aliases := map[string][]string{}
+	if *columns != "" {
+		if aliases, err = lib.LoadAliases(*columns); err != nil {
+			log.Fatal(err)
 		}
 	}
-	defects := open(filepath.Join(os.Args[1], "defects.csv"))
-	stories := open(filepath.Join(os.Args[1], "stories.csv"))
-	features := open(filepath.Join(os.Args[1], "features.csv"))
-	issues := open(filepath.Join(os.Args[1], "siop-issues.csv"))
-	defer func() {
-		defects.Close()
-		stories.Close()
-		features.Close()
-		issues.Close()
-	}()
-	lookupChangeset := func(dc string) (*structs.Change, string) {
-		comm := dc[strings.Index(dc, " - ")+3:]
-		comm = comm[:strings.LastIndex(comm, " - ")]
-		comm = comm[:strings.LastIndex(comm, " - ")]
-		if len(comm) > 56 {
-			comm = comm[:56]
-		}
-		if strings.ToLower(comm) == "<nenhum comentário>" {
-			comm = ""
-		}
-		arr := strings.Split(dc, " - ")
-		author := arr[len(arr)-2]
-		time := arr[len(arr)-1]
-		key := strings.ToLower(fmt.Sprintf("%v - %v - %v", comm, author, time))
-		c := changesets[key]
-		if c == nil {
-			fmt.Fprintf(os.Stderr, "Key not found: %v\n", key)
-		}
-		return c, key
-	}
-	storiesMap := map[string]string{}
-	commits := map[string]*structs.Commit{}
-	r := csv.NewReader(defects)
-	read(r, func(record []string) {
-		defectChangesets := strings.Split(record[4], "\n")
-		for _, dc := range defectChangesets {
-			cs, key := lookupChangeset(dc)
+	workItems, err := lib.LoadRTCWorkItems(dir, aliases)
+	if err != nil {
+		log.Fatal(err)
+	}
+	commits := map[string]*lib.Commit{}
+	eachRow(dir, lib.DefectsSchema.WithAliases(aliases), func(row *lib.Row) error {
+		id := row.Get("Id")
+		if id == "" {
+			return row.Error("empty Id")
+		}
+		for _, dc := range strings.Split(row.Get("Change Sets"), "\n") {
+			if strings.TrimSpace(dc) == "" {
+				continue
+			}
+			cs := m.lookup(fmt.Sprintf("defects.csv:%v", row.Line), dc, "bug "+id)
 			if cs == nil {
 				continue
 			}
-			commits[key] = &structs.Commit{
+			commits[cs.Uuids[0]] = &lib.Commit{
 				Change:  cs,
-				Issue:   structs.Issue{record[1], "bug"},
-				Feature: strings.Split(record[3], ":")[0]}
-			for _, uuid := range cs.Uuids {
-				delete(changesetsByUuid, uuid)
-			}
+				Issue:   lib.Issue{Id: id, Kind: "bug"},
+				Feature: strings.Split(row.Get("Filed Against"), ":")[0]}
 		}
+		return nil
 	})
-	r = csv.NewReader(features)
-	read(r, func(record []string) {
-		storiesMap[record[1]] = strings.Split(record[10], ":")[0]
-	})
-	r = csv.NewReader(stories)
-	read(r, func(record []string) {
-		defectChangesets := strings.Split(record[9], "\n")
-		for _, dc := range defectChangesets {
-			cs, key := lookupChangeset(dc)
+	eachRow(dir, lib.StoriesSchema.WithAliases(aliases), func(row *lib.Row) error {
+		story := row.Get("Story")
+		if !strings.HasPrefix(story, "#") {
+			return row.Error("story %q is not a #<id> reference", story)
+		}
+		story = story[1:]
+		for _, dc := range strings.Split(row.Get("Change Sets"), "\n") {
+			if strings.TrimSpace(dc) == "" {
+				continue
+			}
+			cs := m.lookup(fmt.Sprintf("stories.csv:%v", row.Line), dc, "story "+story)
 			if cs == nil {
 				continue
 			}
-			commits[key] = &structs.Commit{
+			commits[cs.Uuids[0]] = &lib.Commit{
 				Change:  cs,
-				Issue:   structs.Issue{record[8][1:], "story"},
-				Feature: storiesMap[record[8][1:]]}
-			for _, uuid := range cs.Uuids {
-				delete(changesetsByUuid, uuid)
-			}
+				Issue:   lib.Issue{Id: story, Kind: "story"},
+				Feature: workItems.RollUp(story, "feature"),
+				Epic:    workItems.RollUp(story, "epic")}
 		}
+		return nil
 	})
-	r = csv.NewReader(issues)
 	issuesMap := map[string]string{}
-	read(r, func(record []string) {
-		if record[1] == "1" {
-			issuesMap[record[0]] = "bug"
+	eachRow(dir, lib.IssuesSchema.WithAliases(aliases), func(row *lib.Row) error {
+		if row.Get("Type") == "1" {
+			issuesMap[row.Get("Id")] = "bug"
 		} else {
-			issuesMap[record[0]] = "story"
+			issuesMap[row.Get("Id")] = "story"
 		}
+		return nil
 	})
 	re, err := regexp.Compile("#\\d+")
 	if err != nil {
 		log.Fatal(err)
 	}
-	for key, cs := range changesetsByUuid {
+	for key, cs := range m.byUuid {
+		if _, ok := m.links[cs]; ok {
+			continue
+		}
 		change := *cs
 		change.Uuids = []string{key}
-		commits[key] = &structs.Commit{Change: &change}
+		commits[key] = &lib.Commit{Change: &change}
 		issueId := re.FindString(cs.Comment)
 		if issueId != "" {
-			commits[key].Issue = structs.Issue{issueId, issuesMap[issueId[1:]]}
+			commits[key].Issue = lib.Issue{Id: issueId, Kind: issuesMap[issueId[1:]]}
 		}
 	}
-	result := make([]*structs.Commit, 0, len(commits))
-	for _, commit := range commits {
-		commit.Files = []string{}
-		for _, uuid := range commit.Change.Uuids {
-			if uuid == "" {
-				fmt.Fprintf(os.Stderr, "empty uuid\n")
-				continue
-			}
-			cmd := exec.Command("lscm", "list", "changes", "-r", "siop", uuid, "-j")
-			out, err := cmd.CombinedOutput()
-			if err != nil {
-				log.Fatal(err, string(out))
-			}
-			change := &structs.Changeset{}
-			err = json.Unmarshal(out, change)
-			if err != nil {
-				log.Fatal(err)
-			}
-			for _, c := range change.Changes {
-				for _, f := range c.Changes {
-					commit.Files = append(commit.Files, f.Path)
-				}
-			}
+	fmt.Fprintln(os.Stderr, m.summary())
+	if *report != "" {
+		f, err := os.Create(*report)
+		if err != nil {
+			log.Fatal(err)
 		}
+		m.writeReport(f)
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	result := make([]*lib.Commit, 0, len(commits))
+	for _, commit := range commits {
 		result = append(result, commit)
 	}
+	pool := lib.Pool{Workers: *workers}
+	if *verbose {
+		pool.Progress = lib.ProgressPrinter(os.Stderr, "changesets")
+	}
+	err = pool.Run(context.Background(), len(result), func(ctx context.Context, i int) error {
+		var err error
+		result[i].Files, err = changedFiles(ctx, runner, result[i].Change.Uuids, filesByUuid)
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 	json.NewEncoder(os.Stdout).Encode(result)
 }
 
+// changedFiles lists the files of the change sets, from the changesets
+// files when they were fetched through the REST API, or else from lscm.
+func changedFiles(ctx context.Context, runner lib.CommandRunner, uuids []string,
+	filesByUuid map[string][]string) ([]string, error) {
+	files := []string{}
+	for _, uuid := range uuids {
+		if uuid == "" {
+			fmt.Fprintf(os.Stderr, "empty uuid\n")
+			continue
+		}
+		if f, ok := filesByUuid[uuid]; ok {
+			files = append(files, f...)
+			continue
+		}
+		out, err := runner.Output(ctx, "", "lscm", "list", "changes", "-r", "siop", uuid, "-j")
+		if err != nil {
+			return nil, err
+		}
+		change := &lib.Changeset{}
+		err = json.Unmarshal(out, change)
+		if err != nil {
+			return nil, fmt.Errorf("decoding changes of %v: %v", uuid, err)
+		}
+		for _, c := range change.Changes {
+			for _, f := range c.Changes {
+				files = append(files, f.Path)
+			}
+		}
+	}
+	return files, nil
+}
+
+// changesetsMonth returns the month of the changesets files written by
+// siop-log, named as siop-changesets-2012-February.json.
+func changesetsMonth(fileName string) (time.Month, bool) {
+	name := strings.TrimSuffix(fileName, ".json")
+	return lib.ParseMonth(name[strings.LastIndex(name, "-")+1:])
+}
+
 func open(file string) *os.File {
 	result, err := os.Open(file)
 	if err != nil {
@@ -209,16 +238,10 @@ func open(file string) *os.File {
 	return result
 }
 
-func read(r *csv.Reader, f func(record []string)) {
-	for {
-		record, err := r.Read()
-		if err == io.EOF {
-			break
-		} else if err != nil && err != io.EOF {
-			log.Fatal("Error reading defects file ", err)
-		}
-		if record[1] != "Id" {
-			f(record)
-		}
+// eachRow calls f for each row of the export of dir described by schema,
+// failing if it lacks any of the required columns.
+func eachRow(dir string, schema lib.Schema, f func(row *lib.Row) error) {
+	if err := lib.EachExportRow(dir, schema, f); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"../../lib"
+)
+
+// The recordings of testdata/replay are the outputs of "lscm list changes"
+// for two change sets and the failure for one the repository does not have.
+var replay = &lib.Replayer{Dir: filepath.Join("testdata", "replay")}
+
+func TestChangedFilesExpandsUuids(t *testing.T) {
+	filesByUuid := map[string][]string{
+		"_A2jPqFKrDdJwj4xTn6wVRZ": {"/siop/siop-war/WebContent/index.xhtml"}}
+	files, err := changedFiles(context.Background(), replay,
+		[]string{"_A2jPqFKrDdJwj4xTn6wVRZ", "_B3kQrGLsEeKxk5yUo7xWSA", "", "_C4lRsHMtFfLyl6zVp8yXTB"},
+		filesByUuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/siop/siop-war/WebContent/index.xhtml",
+		"/siop/siop-war/WebContent/execucao.xhtml",
+		"/siop/siop-ejb/src/br/gov/siop/ExecucaoBean.java",
+		"/siop/siop-jpa/src/br/gov/siop/Execucao.java"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+}
+
+func TestChangedFilesReportsLscmErrors(t *testing.T) {
+	_, err := changedFiles(context.Background(), replay, []string{"_D5mStINuGgMzm7aWq9zYUC"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "Could not find change set") {
+		t.Errorf("err = %v, want the error of lscm", err)
+	}
+	_, err = changedFiles(context.Background(), replay, []string{"_E6nTuJOvHhNan8bXr0aZVD"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "no recording") {
+		t.Errorf("err = %v, want a missing recording", err)
+	}
+}
+
+const (
+	uuidA = "_A2jPqFKrDdJwj4xTn6wVRZ"
+	uuidB = "_B3kQrGLsEeKxk5yUo7xWSA"
+	uuidC = "_C4lRsHMtFfLyl6zVp8yXTB"
+)
+
+// newTestMatcher returns a matcher of the change sets, given as uuid,
+// comment, author and RFC 3339 time, with a tolerance of 2 minutes.
+func newTestMatcher(t *testing.T, changesets ...[4]string) *matcher {
+	m := newMatcher(lib.TimestampParser{Location: time.UTC}, 2*time.Minute, 0.6)
+	for _, cs := range changesets {
+		modified, err := time.Parse(time.RFC3339, cs[3])
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.add(lib.Change{Uuid: cs[0], Comment: cs[1], Author: cs[2]}, modified)
+	}
+	return m
+}
+
+func TestMatcherPrefersUuids(t *testing.T) {
+	m := newTestMatcher(t,
+		[4]string{uuidA, "30: Crash on report", "Ana Souza", "2012-02-03T14:05:00Z"},
+		[4]string{uuidB, "31: Slow search", "Ana Souza", "2012-02-03T15:00:00Z"})
+	// the comment, author and time are the ones of A, the UUID the one of B
+	c := m.lookup("defects.csv:2", uuidB+" - 30: Crash on report - Ana Souza - 03/02/2012 14:05", "bug 30")
+	if c == nil || c.Uuids[0] != uuidB {
+		t.Fatalf("matched %v, want %v", c, uuidB)
+	}
+	if mt := m.matches[0]; mt.method != "uuid" || mt.confidence != "high" {
+		t.Errorf("matched by %v with %v confidence, want uuid with high", mt.method, mt.confidence)
+	}
+	c = m.lookup("defects.csv:3", uuidA, "bug 30")
+	if c == nil || c.Uuids[0] != uuidA || m.matches[1].method != "uuid" {
+		t.Errorf("bare UUID reference matched %v", c)
+	}
+}
+
+func TestMatcherIgnoresUuidsInText(t *testing.T) {
+	m := newTestMatcher(t,
+		[4]string{uuidA, "30: Revert " + uuidB, "Ana Souza", "2012-02-03T14:05:00Z"},
+		[4]string{uuidB, "31: Slow search", "Ana Souza", "2012-02-03T15:00:00Z"})
+	c := m.lookup("defects.csv:2", "Changes - 30: Revert "+uuidB+" - Ana Souza - 03/02/2012 14:05", "bug 30")
+	if c == nil || c.Uuids[0] != uuidA {
+		t.Fatalf("matched %v, want %v", c, uuidA)
+	}
+	if mt := m.matches[0]; mt.method != "key" || mt.confidence != "high" {
+		t.Errorf("matched by %v with %v confidence, want key with high", mt.method, mt.confidence)
+	}
+}
+
+func TestMatcherFuzzy(t *testing.T) {
+	tests := []struct {
+		name       string
+		ref        string
+		want       string
+		confidence string
+		reason     string
+	}{
+		{"close in time", "Changes - 30: Crash on report - Ana Souza - 03/02/2012 14:05",
+			uuidA, "medium", ""},
+		{"edited comment", "Changes - 30: Crash in the report - Ana Souza - 03/02/2012 14:05",
+			uuidA, "low", ""},
+		{"other author", "Changes - 30: Crash on report - Rui Lima - 03/02/2012 14:05",
+			"", "", "no change set of the author within the tolerance"},
+		{"beyond the tolerance", "Changes - 30: Crash on report - Ana Souza - 03/02/2012 14:08",
+			"", "", "no change set of the author within the tolerance"},
+		{"unrelated comment", "Changes - 99: Upgrade libraries - Ana Souza - 03/02/2012 14:06",
+			"", "", "best candidate " + uuidA + " scored"},
+		{"malformed", "Changes - 30: Crash on report", "", "", "malformed change set reference"},
+		{"bad time", "Changes - 30: Crash on report - Ana Souza - 03/02/2012 25:05", "", "", "invalid time"},
+	}
+	for _, test := range tests {
+		m := newTestMatcher(t,
+			[4]string{uuidA, "30: Crash on report", "Ana Souza", "2012-02-03T14:04:50Z"})
+		c := m.lookup("defects.csv:2", test.ref, "bug 30")
+		mt := m.matches[0]
+		if test.want == "" {
+			if c != nil {
+				t.Errorf("%v: matched %v, want none", test.name, c.Uuids)
+			} else if !strings.Contains(mt.reason, test.reason) {
+				t.Errorf("%v: reason %q, want %q", test.name, mt.reason, test.reason)
+			}
+			continue
+		}
+		if c == nil || c.Uuids[0] != test.want {
+			t.Errorf("%v: matched %v (%v), want %v", test.name, c, mt.reason, test.want)
+			continue
+		}
+		if mt.method != "fuzzy" || mt.confidence != test.confidence || mt.score < 0.6 || mt.score >= 1 {
+			t.Errorf("%v: matched by %v with %v confidence and score %.2f, want fuzzy with %v",
+				test.name, mt.method, mt.confidence, mt.score, test.confidence)
+		}
+	}
+}
+
+func TestMatcherAmbiguousCandidates(t *testing.T) {
+	m := newTestMatcher(t,
+		[4]string{uuidA, "30: Crash on report", "Ana Souza", "2012-02-03T14:04:50Z"},
+		[4]string{uuidB, "30: Crash on report", "Ana Souza", "2012-02-03T14:05:10Z"})
+	c := m.lookup("defects.csv:2", "Changes - 30: Crash on report - Ana Souza - 03/02/2012 14:04:58", "bug 30")
+	mt := m.matches[0]
+	if c == nil || mt.confidence != "low" || !strings.Contains(mt.reason, "ambiguous with") {
+		t.Errorf("matched %v with %v confidence (%v), want a low confidence ambiguous match",
+			c, mt.confidence, mt.reason)
+	}
+}
+
+func TestMatcherCollisions(t *testing.T) {
+	m := newTestMatcher(t,
+		[4]string{uuidA, "30: Crash on report", "Ana Souza", "2012-02-03T14:05:10Z"},
+		[4]string{uuidB, "30: Crash on report", "Ana Souza", "2012-02-03T14:05:40Z"},
+		[4]string{uuidC, "31: Slow search", "Ana Souza", "2012-02-03T15:00:00Z"})
+	if len(m.collisions) != 1 {
+		t.Fatalf("%v collisions, want 1", len(m.collisions))
+	}
+	if m.byUuid[uuidA] != m.byUuid[uuidB] || !reflect.DeepEqual(m.byUuid[uuidA].Uuids, []string{uuidA, uuidB}) {
+		t.Errorf("colliding change sets were not merged: %v, %v", m.byUuid[uuidA], m.byUuid[uuidB])
+	}
+	m.lookup("defects.csv:2", "Changes - 30: Crash on report - Ana Souza - 03/02/2012 14:05", "bug 30")
+	m.lookup("stories.csv:2", "Changes - 30: Crash on report - Ana Souza - 03/02/2012 14:05", "story 12")
+	if reason := m.matches[1].reason; !strings.Contains(reason, "also linked to bug 30") {
+		t.Errorf("reason %q, want the change set linked to two issues", reason)
+	}
+}
+
+func TestMatcherReport(t *testing.T) {
+	m := newTestMatcher(t,
+		[4]string{uuidA, "30: Crash on report", "Ana Souza", "2012-02-03T14:05:10Z"},
+		[4]string{uuidB, "30: Crash on report", "Ana Souza", "2012-02-03T14:05:40Z"},
+		[4]string{uuidC, "31: Slow search", "Ana Souza", "2012-02-03T15:00:00Z"})
+	m.lookup("defects.csv:2", "Changes - 30: Crash on report - Ana Souza - 03/02/2012 14:05", "bug 30")
+	m.lookup("defects.csv:3", uuidC, "bug 31")
+	m.lookup("defects.csv:4", "Changes - 32: Lost order - Rui Lima - 03/02/2012 16:00", "bug 32")
+	var b strings.Builder
+	m.writeReport(&b)
+	want := "source\tmethod\tconfidence\tscore\tchange sets\treference\tnote\n" +
+		"defects.csv:2\tkey\thigh\t1.00\t" + uuidA + " " + uuidB +
+		"\t\"Changes - 30: Crash on report - Ana Souza - 03/02/2012 14:05\"\t\n" +
+		"defects.csv:3\tuuid\thigh\t1.00\t" + uuidC + "\t\"" + uuidC + "\"\t\n" +
+		"\nunmatched\n" +
+		"defects.csv:4\t\"Changes - 32: Lost order - Rui Lima - 03/02/2012 16:00\"\t" +
+		"no change set of the author within the tolerance\n" +
+		"\ncollisions\n" +
+		"\"30: crash on report - ana souza - 03/02/2012 14:05\"\t" + uuidA + " " + uuidB + "\n"
+	if b.String() != want {
+		t.Errorf("report\n%v\nwant\n%v", b.String(), want)
+	}
+	if s := m.summary(); s != "matched 1 by uuid, 1 by key, 0 fuzzily; 1 unmatched; 1 collisions" {
+		t.Errorf("summary %q", s)
+	}
+}
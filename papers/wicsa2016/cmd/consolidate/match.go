@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"../../lib"
+)
+
+// matcher links the change sets listed in the work item exports to the ones
+// fetched from the repository: by change set UUID when the export has it, by
+// the comment, author and minute of the change set, and otherwise by the
+// best scored change set of the author within the time tolerance.
+type matcher struct {
+	parser     lib.TimestampParser
+	tolerance  time.Duration
+	minScore   float64
+	byUuid     map[string]*lib.Change
+	byKey      map[string]*lib.Change
+	byAuthor   map[string][]*lib.Change
+	collisions map[string]*lib.Change
+	links      map[*lib.Change]string
+	matches    []*match
+}
+
+type match struct {
+	source     string
+	ref        string
+	change     *lib.Change
+	method     string
+	score      float64
+	confidence string
+	reason     string
+}
+
+func newMatcher(parser lib.TimestampParser, tolerance time.Duration, minScore float64) *matcher {
+	if parser.Order == lib.UnknownOrder {
+		// the keys, like the exports, have day first dates
+		parser.Order = lib.DayFirst
+	}
+	return &matcher{
+		parser:     parser,
+		tolerance:  tolerance,
+		minScore:   minScore,
+		byUuid:     map[string]*lib.Change{},
+		byKey:      map[string]*lib.Change{},
+		byAuthor:   map[string][]*lib.Change{},
+		collisions: map[string]*lib.Change{},
+		links:      map[*lib.Change]string{}}
+}
+
+func trimComment(comment string) string {
+	if len(comment) > 56 {
+		comment = comment[:56]
+	}
+	if strings.ToLower(comment) == "<nenhum comentário>" {
+		comment = ""
+	}
+	return comment
+}
+
+func changesetKey(comment, author, modified string) string {
+	return strings.ToLower(fmt.Sprintf("%v - %v - %v", trimComment(comment), author, modified))
+}
+
+// add registers a change set. Change sets sharing a key are delivered
+// together by RTC and are merged into one change, which is reported.
+func (m *matcher) add(c lib.Change, t time.Time) *lib.Change {
+	key := changesetKey(c.Comment, c.Author, t.In(m.parser.Location).Format("02/01/2006 15:04"))
+	if change, ok := m.byKey[key]; ok {
+		change.Uuids = append(change.Uuids, c.Uuid)
+		m.byUuid[c.Uuid] = change
+		m.collisions[key] = change
+		return change
+	}
+	change := &lib.Change{
+		Author:       c.Author,
+		Comment:      trimComment(c.Comment),
+		Modified:     t.Format(time.RFC3339),
+		ModifiedTime: t,
+		Uuids:        []string{c.Uuid}}
+	m.byKey[key] = change
+	m.byUuid[c.Uuid] = change
+	author := strings.ToLower(c.Author)
+	m.byAuthor[author] = append(m.byAuthor[author], change)
+	return change
+}
+
+var uuidRegex = regexp.MustCompile(`^_[A-Za-z0-9_-]{22}$`)
+
+// refUuid returns the change set UUID of ref, when the export gives it as
+// the whole reference or as its label, and not merely somewhere in the text.
+func refUuid(ref string) string {
+	for _, s := range []string{ref, strings.SplitN(ref, " - ", 2)[0]} {
+		if s = strings.TrimSpace(s); uuidRegex.MatchString(s) {
+			return s
+		}
+	}
+	return ""
+}
+
+// lookup finds the change set described by ref, a line of the change sets
+// column of an export formatted as "<label> - <comment> - <author> - <time>".
+func (m *matcher) lookup(source, ref, issue string) *lib.Change {
+	mt := &match{source: source, ref: ref}
+	m.matches = append(m.matches, mt)
+	if uuid := refUuid(ref); uuid != "" {
+		if c, ok := m.byUuid[uuid]; ok {
+			return m.found(mt, c, "uuid", 1, "high", issue)
+		}
+	}
+	arr := strings.Split(ref, " - ")
+	if len(arr) < 4 {
+		mt.reason = "malformed change set reference"
+		return nil
+	}
+	comm := strings.Join(arr[1:len(arr)-2], " - ")
+	author, modified := arr[len(arr)-2], arr[len(arr)-1]
+	key := changesetKey(comm, author, modified)
+	if c, ok := m.byKey[key]; ok {
+		return m.found(mt, c, "key", 1, "high", issue)
+	}
+	t, err := m.parser.Parse(modified)
+	if err != nil {
+		mt.reason = err.Error()
+		return nil
+	}
+	var best, second *lib.Change
+	var bestScore, secondScore float64
+	for _, c := range m.byAuthor[strings.ToLower(author)] {
+		dt := c.ModifiedTime.Sub(t)
+		if dt < 0 {
+			dt = -dt
+		}
+		if dt > m.tolerance {
+			continue
+		}
+		score := 0.6*similarity(strings.ToLower(trimComment(comm)), strings.ToLower(c.Comment)) +
+			0.4*(1-float64(dt)/float64(m.tolerance+time.Minute))
+		if score > bestScore {
+			second, secondScore = best, bestScore
+			best, bestScore = c, score
+		} else if score > secondScore {
+			second, secondScore = c, score
+		}
+	}
+	if best == nil || bestScore < m.minScore {
+		mt.reason = "no change set of the author within the tolerance"
+		if best != nil {
+			mt.reason = fmt.Sprintf("best candidate %v scored %.2f", best.Uuids[0], bestScore)
+		}
+		return nil
+	}
+	confidence := "medium"
+	if bestScore < 0.9 {
+		confidence = "low"
+	}
+	if second != nil && bestScore-secondScore < 0.05 {
+		confidence = "low"
+		mt.reason = fmt.Sprintf("ambiguous with %v (%.2f)", second.Uuids[0], secondScore)
+	}
+	return m.found(mt, best, "fuzzy", bestScore, confidence, issue)
+}
+
+func (m *matcher) found(mt *match, c *lib.Change, method string, score float64,
+	confidence, issue string) *lib.Change {
+	mt.change, mt.method, mt.score, mt.confidence = c, method, score, confidence
+	if previous, ok := m.links[c]; ok && previous != issue {
+		mt.reason = fmt.Sprintf("change set also linked to %v", previous)
+	}
+	m.links[c] = issue
+	return c
+}
+
+// similarity is 1 minus the normalized edit distance between a and b.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	n := len(ra)
+	if len(rb) > n {
+		n = len(rb)
+	}
+	return 1 - float64(prev[len(rb)])/float64(n)
+}
+
+// writeReport lists every reference with how it was matched, followed by
+// the unmatched references and the change sets merged by key.
+func (m *matcher) writeReport(w io.Writer) {
+	fmt.Fprintln(w, "source\tmethod\tconfidence\tscore\tchange sets\treference\tnote")
+	unmatched := []*match{}
+	for _, mt := range m.matches {
+		if mt.change == nil {
+			unmatched = append(unmatched, mt)
+			continue
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%.2f\t%v\t%q\t%v\n", mt.source, mt.method, mt.confidence,
+			mt.score, strings.Join(mt.change.Uuids, " "), mt.ref, mt.reason)
+	}
+	fmt.Fprintln(w, "\nunmatched")
+	for _, mt := range unmatched {
+		fmt.Fprintf(w, "%v\t%q\t%v\n", mt.source, mt.ref, mt.reason)
+	}
+	fmt.Fprintln(w, "\ncollisions")
+	keys := make([]string, 0, len(m.collisions))
+	for k := range m.collisions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%q\t%v\n", k, strings.Join(m.collisions[k].Uuids, " "))
+	}
+}
+
+func (m *matcher) summary() string {
+	methods := map[string]int{}
+	unmatched := 0
+	for _, mt := range m.matches {
+		if mt.change == nil {
+			unmatched++
+		} else {
+			methods[mt.method]++
+		}
+	}
+	return fmt.Sprintf("matched %v by uuid, %v by key, %v fuzzily; %v unmatched; "+
+		"%v collisions", methods["uuid"], methods["key"], methods["fuzzy"], unmatched,
+		len(m.collisions))
+}
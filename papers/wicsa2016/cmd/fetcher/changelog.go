@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"../../lib"
+)
+
+// The XML views of Jira have no changelog, which comes from its REST API.
+type searchResult struct {
+	StartAt int         `json:"startAt"`
+	Total   int         `json:"total"`
+	Issues  []restIssue `json:"issues"`
+}
+
+type restIssue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Created        string `json:"created"`
+		ResolutionDate string `json:"resolutiondate"`
+	} `json:"fields"`
+	Changelog struct {
+		Histories []struct {
+			Created string `json:"created"`
+			Items   []struct {
+				Field      string `json:"field"`
+				FromString string `json:"fromString"`
+				ToString   string `json:"toString"`
+			} `json:"items"`
+		} `json:"histories"`
+	} `json:"changelog"`
+}
+
+const restTime = "2006-01-02T15:04:05.000-0700"
+
+func parseRestTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(restTime, s)
+}
+
+func (i *restIssue) history() (*lib.IssueHistory, error) {
+	h := &lib.IssueHistory{Key: i.Key}
+	var err error
+	if h.Created, err = parseRestTime(i.Fields.Created); err != nil {
+		return nil, fmt.Errorf("%v: %v", i.Key, err)
+	}
+	if h.Resolved, err = parseRestTime(i.Fields.ResolutionDate); err != nil {
+		return nil, fmt.Errorf("%v: %v", i.Key, err)
+	}
+	for _, c := range i.Changelog.Histories {
+		at, err := parseRestTime(c.Created)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", i.Key, err)
+		}
+		for _, item := range c.Items {
+			if item.Field == "status" || item.Field == "resolution" {
+				h.Transitions = append(h.Transitions, lib.Transition{Time: at, Field: item.Field,
+					From: item.FromString, To: item.ToString})
+			}
+		}
+	}
+	return h, nil
+}
+
+// changelogs returns the histories of the issues matching jql.
+func (f *fetcher) changelogs(jql string) ([]*lib.IssueHistory, error) {
+	histories := []*lib.IssueHistory{}
+	for start := 0; ; {
+		u := fmt.Sprintf("%v/rest/api/2/search?jql=%v&fields=created,resolutiondate&"+
+			"expand=changelog&maxResults=%v&startAt=%v", f.rest, url.QueryEscape(jql), pageSize, start)
+		b, err := f.client.get(u)
+		if err != nil {
+			return nil, err
+		}
+		var result searchResult
+		if err := json.Unmarshal(b, &result); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", u, err)
+		}
+		for i := range result.Issues {
+			h, err := result.Issues[i].history()
+			if err != nil {
+				return nil, err
+			}
+			histories = append(histories, h)
+		}
+		start += len(result.Issues)
+		if len(result.Issues) == 0 || start >= result.Total {
+			return histories, nil
+		}
+	}
+}
+
+// writeChangelog writes the histories to file, keeping those of old not
+// fetched again, in key order.
+func writeChangelog(file string, old map[string]*lib.IssueHistory, fetched []*lib.IssueHistory) error {
+	histories := map[string]*lib.IssueHistory{}
+	for k, h := range old {
+		histories[k] = h
+	}
+	for _, h := range fetched {
+		histories[h.Key] = h
+	}
+	keys := []string{}
+	for k := range histories {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keyLess(keys[i], keys[j]) })
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(out)
+	w.Write(lib.ChangelogHeader())
+	for _, k := range keys {
+		for _, r := range histories[k].Records() {
+			w.Write(r)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
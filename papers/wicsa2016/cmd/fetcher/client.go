@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxBackoff = 2 * time.Minute
+
+// client gets the pages of a tracker, spacing the requests by interval and
+// retrying network errors, 429 and 5xx responses with exponential backoff.
+// Pages are kept in dir, when set, so an interrupted fetch resumes from the
+// last page completed.
+type client struct {
+	http     *http.Client
+	interval time.Duration
+	retries  int
+	backoff  time.Duration
+	dir      string
+	last     time.Time
+}
+
+type statusError struct {
+	url        string
+	code       int
+	status     string
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: %v", e.url, e.status)
+}
+
+func (c *client) get(u string) ([]byte, error) {
+	if c.dir != "" {
+		if b, ok := c.load(u); ok {
+			return b, nil
+		}
+	}
+	b, contentType, err := c.fetch(u)
+	if err != nil {
+		return nil, err
+	}
+	if c.dir != "" {
+		if err := c.save(u, b, pageExtension(contentType, b)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// path returns the path of the page of u without its extension, which tells
+// the content type of the page.
+func (c *client) path(u string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%x", sha1.Sum([]byte(u))))
+}
+
+func (c *client) load(u string) ([]byte, bool) {
+	for _, ext := range []string{".json", ".xml", ".page"} {
+		if b, err := ioutil.ReadFile(c.path(u) + ext); err == nil {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+func (c *client) save(u string, b []byte, ext string) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	tmp := c.path(u) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path(u)+ext)
+}
+
+// pageExtension names pages after their content type, or their first
+// character when the tracker does not tell it.
+func pageExtension(contentType string, b []byte) string {
+	switch t := strings.ToLower(contentType); {
+	case strings.Contains(t, "json"):
+		return ".json"
+	case strings.Contains(t, "xml"):
+		return ".xml"
+	}
+	switch trimmed := bytes.TrimSpace(b); {
+	case bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("[")):
+		return ".json"
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return ".xml"
+	}
+	return ".page"
+}
+
+// clear removes the pages kept, once the fetch is complete.
+func (c *client) clear() error {
+	if c.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(c.dir)
+}
+
+func (c *client) fetch(u string) ([]byte, string, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		b, contentType, err := c.do(u)
+		if err == nil || !retryable(err) || attempt >= c.retries {
+			return b, contentType, err
+		}
+		wait := backoff
+		if e, ok := err.(*statusError); ok && e.retryAfter > wait {
+			wait = e.retryAfter
+		}
+		fmt.Fprintf(os.Stderr, "fetcher: %v, retrying in %v\n", err, wait)
+		time.Sleep(wait)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *client) do(u string) ([]byte, string, error) {
+	if wait := c.interval - time.Since(c.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	c.last = time.Now()
+	resp, err := c.http.Get(u)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading %s: %v", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &statusError{url: u, code: resp.StatusCode, status: resp.Status}
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			e.retryAfter = time.Duration(s) * time.Second
+		}
+		return nil, "", e
+	}
+	return b, resp.Header.Get("Content-Type"), nil
+}
+
+func retryable(err error) bool {
+	if e, ok := err.(*statusError); ok {
+		return e.code == http.StatusTooManyRequests || e.code >= 500
+	}
+	// network errors and truncated responses
+	return true
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyJira serves total issues through the XML search and REST views of
+// Jira, failing the requests fail tells it to for each page start.
+type flakyJira struct {
+	total int
+	mu    sync.Mutex
+	// fail returns the failure of the attempt (counting from 0) of the page
+	// at start: a status, 0 for success or -1 to drop the connection
+	// mid-page.
+	fail     func(start, attempt int) int
+	attempts map[int]int
+	served   map[int]int
+}
+
+func newFlakyJira(t *testing.T, total int, fail func(start, attempt int) int) (*flakyJira, *httptest.Server) {
+	j := &flakyJira{total: total, fail: fail, attempts: map[int]int{}, served: map[int]int{}}
+	server := httptest.NewServer(j)
+	t.Cleanup(server.Close)
+	return j, server
+}
+
+func (j *flakyJira) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := r.URL.Path == "/rest/api/2/search"
+	start, _ := strconv.Atoi(r.URL.Query().Get("pager/start"))
+	if rest {
+		start, _ = strconv.Atoi(r.URL.Query().Get("startAt"))
+	}
+	j.mu.Lock()
+	attempt := j.attempts[start]
+	j.attempts[start]++
+	status := 0
+	if j.fail != nil {
+		status = j.fail(start, attempt)
+	}
+	if status == 0 {
+		j.served[start]++
+	}
+	j.mu.Unlock()
+	switch {
+	case status == -1:
+		conn, buf, _ := w.(http.Hijacker).Hijack()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 4096\r\n\r\n<rss><channel><item>")
+		buf.Flush()
+		conn.Close()
+		return
+	case status == http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", "0")
+		fallthrough
+	case status != 0:
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	end := start + pageSize
+	if end > j.total {
+		end = j.total
+	}
+	if rest {
+		result := map[string]interface{}{"startAt": start, "total": j.total}
+		issues := []map[string]interface{}{}
+		for i := start; i < end; i++ {
+			issues = append(issues, map[string]interface{}{"key": fmt.Sprintf("OFBIZ-%v", i+1),
+				"fields": map[string]string{"created": "2012-02-03T14:05:22.000-0200"}})
+		}
+		result["issues"] = issues
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+	fmt.Fprint(w, "<rss><channel>")
+	for i := start; i < end; i++ {
+		fmt.Fprintf(w, "<item><key>OFBIZ-%v</key><type>Bug</type><title>Issue %v</title></item>", i+1, i+1)
+	}
+	fmt.Fprint(w, "</channel></rss>")
+}
+
+func newTestFetcher(base, dir string, retries int) *fetcher {
+	c := &client{http: &http.Client{Timeout: 10 * time.Second}, retries: retries,
+		backoff: time.Millisecond, dir: dir}
+	return &fetcher{tracker: tracker{url: base + "/sr/SearchRequest.xml", rest: base, project: "OFBIZ"},
+		client: c, issues: map[string]*Item{}, parents: map[string]*Item{}}
+}
+
+func checkKeys(t *testing.T, keys []string, total int) {
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if seen[k] {
+			t.Errorf("issue %v fetched twice", k)
+		}
+		seen[k] = true
+	}
+	for i := 1; i <= total; i++ {
+		if k := fmt.Sprintf("OFBIZ-%v", i); !seen[k] {
+			t.Errorf("issue %v missing", k)
+		}
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	jira, server := newFlakyJira(t, 250, func(start, attempt int) int {
+		switch {
+		case start == 100 && attempt == 0:
+			return http.StatusServiceUnavailable
+		case start == 200 && attempt == 0:
+			return http.StatusTooManyRequests
+		case start == 200 && attempt == 1:
+			return -1
+		}
+		return 0
+	})
+	f := newTestFetcher(server.URL, "", 3)
+	items, err := f.search("project = OFBIZ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	checkKeys(t, keys, 250)
+	if jira.attempts[200] != 3 {
+		t.Errorf("page 200 requested %v times, want 3", jira.attempts[200])
+	}
+}
+
+func TestClientResumesFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	killed := true
+	jira, server := newFlakyJira(t, 250, func(start, attempt int) int {
+		if killed && start == 200 {
+			return http.StatusInternalServerError
+		}
+		return 0
+	})
+	if _, err := newTestFetcher(server.URL, dir, 1).search("project = OFBIZ"); err == nil {
+		t.Fatal("the fetch of the failing page succeeded")
+	}
+	pages, _ := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if len(pages) != 2 {
+		t.Fatalf("%v pages kept, want the 2 completed", len(pages))
+	}
+	jira.mu.Lock()
+	killed = false
+	jira.mu.Unlock()
+	items, err := newTestFetcher(server.URL, dir, 1).search("project = OFBIZ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	checkKeys(t, keys, 250)
+	for _, start := range []int{0, 100, 200} {
+		if jira.served[start] != 1 {
+			t.Errorf("page %v served %v times, want once", start, jira.served[start])
+		}
+	}
+}
+
+func TestChangelogPagesAreJSON(t *testing.T) {
+	dir := t.TempDir()
+	_, server := newFlakyJira(t, 150, func(start, attempt int) int {
+		if start == 100 && attempt == 0 {
+			return -1
+		}
+		return 0
+	})
+	histories, err := newTestFetcher(server.URL, dir, 2).changelogs("project = OFBIZ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, h := range histories {
+		keys = append(keys, h.Key)
+	}
+	checkKeys(t, keys, 150)
+	json, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	xml, _ := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if len(json) != 2 || len(xml) != 0 {
+		t.Errorf("kept %v JSON and %v XML pages, want 2 JSON ones", len(json), len(xml))
+	}
+}
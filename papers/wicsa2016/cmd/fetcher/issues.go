@@ -2,10 +2,20 @@ package main
 
 import (
 	"encoding/xml"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"html"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"../../lib"
 )
 
 type Rss struct {
@@ -13,77 +23,350 @@ type Rss struct {
 }
 
 type Item struct {
-	Title   string `xml:"title"`
-	Key     string `xml:"key"`
-	Type    string `xml:"type"`
-	Parent  string `xml:"parent"`
-	Created string `xml:"created"`
+	Title        string        `xml:"title"`
+	Description  string        `xml:"description"`
+	Key          string        `xml:"key"`
+	Type         string        `xml:"type"`
+	Parent       string        `xml:"parent"`
+	Created      string        `xml:"created"`
+	OriginalType string        `xml:"-"`
+	Epic         string        `xml:"-"`
+	Links        []lib.Link    `xml:"-"`
+	Components   []string      `xml:"component"`
+	Labels       []string      `xml:"labels>label"`
+	LinkTypes    []LinkType    `xml:"issuelinks>issuelinktype"`
+	CustomFields []CustomField `xml:"customfields>customfield"`
 }
 
-var urls = map[string]string{
-	"ofbiz": "https://issues.apache.org/jira/sr/jira.issueviews:searchrequest-xml/temp/" +
-		"SearchRequest.xml?jqlQuery=project+%3D+OFBIZ&tempMax=100&" +
-		"field=key&field=title&field=type&field=created&field=parent&pager/start=",
-	"openmrs": "https://issues.openmrs.org/sr/jira.issueviews:searchrequest-xml/temp/" +
-		"SearchRequest.xml?jqlQuery=project+%3D+TRUNK&tempMax=100&" +
-		"field=key&field=title&field=type&field=created&field=parent&pager/start="}
+type LinkType struct {
+	Outward LinkGroup `xml:"outwardlinks"`
+	Inward  LinkGroup `xml:"inwardlinks"`
+}
 
-func main() {
+type LinkGroup struct {
+	Description string   `xml:"description,attr"`
+	Keys        []string `xml:"issuelink>issuekey"`
+}
+
+type CustomField struct {
+	Key    string   `xml:"key,attr"`
+	Name   string   `xml:"customfieldname"`
+	Values []string `xml:"customfieldvalues>customfieldvalue"`
+}
+
+// ingest keeps what is written from the XML fields of a fetched item.
+func (item *Item) ingest() {
+	item.OriginalType = item.Type
+	item.Epic = item.epic()
+	item.Links = item.links()
+	item.Description = plainText(item.Description)
+}
+
+var tagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// plainText strips the markup of the HTML descriptions of Jira.
+func plainText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(tagRegex.ReplaceAllString(s, " "))), " ")
+}
+
+func (item *Item) workItem() *lib.WorkItem {
+	return &lib.WorkItem{Id: item.Key, Kind: item.Type, OriginalKind: item.OriginalType,
+		Title: item.Title, Description: item.Description, Parent: item.Parent, Links: item.Links,
+		Components: item.Components, Labels: item.Labels}
+}
 
-	if len(os.Args) < 2 {
-		fmt.Fprintln(os.Stderr, "usage: issues <repository>")
+func (item *Item) epic() string {
+	for _, f := range item.CustomFields {
+		if (strings.HasSuffix(f.Key, ":gh-epic-link") || f.Name == "Epic Link") && len(f.Values) > 0 {
+			return strings.TrimSpace(f.Values[0])
+		}
+	}
+	return ""
+}
+
+func (item *Item) links() []lib.Link {
+	links := []lib.Link{}
+	for _, t := range item.LinkTypes {
+		for _, g := range []LinkGroup{t.Outward, t.Inward} {
+			for _, k := range g.Keys {
+				links = append(links, lib.Link{Kind: lib.NormalizeLinkKind(g.Description), Target: k})
+			}
+		}
+	}
+	return links
+}
+
+const fields = "field=key&field=title&field=type&field=created&field=parent&" +
+	"field=description&field=issuelinks&field=components&field=labels&field=allcustom"
+
+const pageSize = 100
+
+type tracker struct {
+	url     string
+	rest    string
+	project string
+}
+
+var trackers = map[string]tracker{
+	"ofbiz": {"https://issues.apache.org/jira/sr/jira.issueviews:searchrequest-xml/temp/" +
+		"SearchRequest.xml", "https://issues.apache.org/jira", "OFBIZ"},
+	"openmrs": {"https://issues.openmrs.org/sr/jira.issueviews:searchrequest-xml/temp/" +
+		"SearchRequest.xml", "https://issues.openmrs.org", "TRUNK"}}
+
+type fetcher struct {
+	tracker
+	client *client
+	issues map[string]*Item
+	// parents holds the ancestors of sub-tasks missing from the search, which
+	// are used to resolve types but not written.
+	parents map[string]*Item
+}
+
+func (f *fetcher) search(jql string) ([]Item, error) {
+	items := []Item{}
+	for start := 0; ; start += pageSize {
+		u := fmt.Sprintf("%v?jqlQuery=%v&tempMax=%v&%v&pager/start=%v",
+			f.url, url.QueryEscape(jql), pageSize, fields, start)
+		b, err := f.client.get(u)
+		if err != nil {
+			return nil, err
+		}
+		rss := Rss{}
+		if err := xml.Unmarshal(b, &rss); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", u, err)
+		}
+		for i := range rss.Items {
+			rss.Items[i].ingest()
+		}
+		items = append(items, rss.Items...)
+		if len(rss.Items) < pageSize {
+			return items, nil
+		}
+	}
+}
+
+// issue returns the issue key, fetching it when it is missing, or nil if
+// the tracker does not know it.
+func (f *fetcher) issue(key string) (*Item, error) {
+	if item := f.issues[key]; item != nil {
+		return item, nil
+	}
+	if item, ok := f.parents[key]; ok {
+		return item, nil
+	}
+	items, err := f.search("key = " + key)
+	if e, ok := err.(*statusError); ok && e.code == http.StatusBadRequest {
+		// Jira rejects queries for keys that do not exist
+		items, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.parents[key] = nil
+	for i := range items {
+		if items[i].Key == key {
+			f.parents[key] = &items[i]
+		}
+	}
+	return f.parents[key], nil
+}
+
+func isSubtask(item *Item) bool {
+	return strings.EqualFold(item.Type, "Sub-task")
+}
+
+// resolve gives the sub-task item the type of its closest ancestor that is
+// not a sub-task, and so to the sub-tasks in between.
+func (f *fetcher) resolve(item *Item) error {
+	path := []*Item{}
+	seen := map[string]bool{item.Key: true}
+	for cur := item; isSubtask(cur); {
+		path = append(path, cur)
+		if cur.Parent == "" {
+			return fmt.Errorf("%v has no parent", cur.Key)
+		}
+		if seen[cur.Parent] {
+			return fmt.Errorf("cycle through %v", cur.Parent)
+		}
+		seen[cur.Parent] = true
+		parent, err := f.issue(cur.Parent)
+		if err != nil {
+			return fmt.Errorf("fetching parent %v: %v", cur.Parent, err)
+		}
+		if parent == nil {
+			return fmt.Errorf("parent %v not found", cur.Parent)
+		}
+		cur = parent
+		if !isSubtask(cur) {
+			for _, p := range path {
+				p.Type = cur.Type
+			}
+		}
+	}
+	return nil
+}
+
+// keyLess orders keys such as OFBIZ-9 and OFBIZ-10 by project and number.
+func keyLess(a, b string) bool {
+	pa, na := splitKey(a)
+	pb, nb := splitKey(b)
+	if pa != pb {
+		return pa < pb
+	}
+	if na != nb {
+		return na < nb
+	}
+	return a < b
+}
+
+func splitKey(key string) (string, int) {
+	i := strings.LastIndex(key, "-")
+	if i < 0 {
+		return key, 0
+	}
+	n, _ := strconv.Atoi(key[i+1:])
+	return key[:i], n
+}
+
+func main() {
+	base := flag.String("url", "", "search request URL overriding the one of the repository")
+	checkpoint := flag.String("checkpoint", filepath.Join(lib.DefaultCacheDir(), "fetcher"),
+		"directory keeping the pages fetched until the fetch completes (empty disables it)")
+	rate := flag.Float64("rate", 2, "maximum requests per second")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of each request")
+	retries := flag.Int("retries", 8, "retries of requests failing with network errors, 429 or 5xx")
+	backoff := flag.Duration("backoff", time.Second, "wait before the first retry, doubled on each retry")
+	rest := flag.String("rest", "", "Jira base URL of the REST API overriding the one of the repository")
+	changelog := flag.String("changelog", "",
+		"file to write the status changes of the issues to, merged into when -incremental")
+	storeFile := flag.String("store", "", "issues file to write and keep current instead of the standard output")
+	incremental := flag.Bool("incremental", false,
+		"fetch only the issues updated since the last fetch into the store, merging them")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: issues [flags] <repository>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	url := urls[os.Args[1]]
-	if url == "" {
+	t, ok := trackers[flag.Arg(0)]
+	if !ok {
 		fmt.Fprint(os.Stderr, "please choose one repository as follow: ")
-		for k, _ := range urls {
+		for k, _ := range trackers {
 			fmt.Fprint(os.Stderr, k, " ")
 		}
 		fmt.Fprintln(os.Stderr, "")
 		os.Exit(1)
 	}
-	start := 0
-	issues := map[string]*Item{}
-	for {
-		resp, err := http.Get(url + fmt.Sprint(start))
-		if err != nil {
+	if *base != "" {
+		t.url = *base
+	}
+	if *rest != "" {
+		t.rest = *rest
+	}
+	if *incremental && *storeFile == "" {
+		fmt.Fprintln(os.Stderr, "fetcher: -incremental requires -store")
+		os.Exit(1)
+	}
+	c := &client{http: &http.Client{Timeout: *timeout}, retries: *retries, backoff: *backoff}
+	if *rate > 0 {
+		c.interval = time.Duration(float64(time.Second) / *rate)
+	}
+	if *checkpoint != "" {
+		c.dir = filepath.Join(*checkpoint, flag.Arg(0))
+	}
+	f := &fetcher{tracker: t, client: c, issues: map[string]*Item{}, parents: map[string]*Item{}}
+	st := store{*storeFile}
+	stored := map[string]*Item{}
+	started := time.Now()
+	jql := "project = " + t.project
+	if *storeFile != "" {
+		var err error
+		if stored, err = st.load(); err != nil {
 			fmt.Fprintf(os.Stderr, "fetcher: %v\n", err)
 			os.Exit(1)
 		}
-		b, err := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
+	}
+	if *incremental {
+		since, err := st.lastSync(t.project)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "fetcher: reading %s: %v\n", url, err)
+			fmt.Fprintf(os.Stderr, "fetcher: %v\n", err)
 			os.Exit(1)
 		}
-		rss := Rss{}
-		err = xml.Unmarshal(b, &rss)
+		if !since.IsZero() {
+			jql += fmt.Sprintf(" AND updated >= \"%v\"", since.Add(-syncOverlap).Format("2006/01/02 15:04"))
+		}
+		for k, item := range stored {
+			i := *item
+			i.Type = i.OriginalType
+			f.issues[k] = &i
+		}
+	}
+	items, err := f.search(jql)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fetcher: %v\n", err)
+		if c.dir != "" {
+			fmt.Fprintf(os.Stderr, "fetcher: pages fetched are kept in %v, run again to resume\n", c.dir)
+		}
+		os.Exit(1)
+	}
+	for i := range items {
+		f.issues[items[i].Key] = &items[i]
+	}
+	if *changelog != "" {
+		old := map[string]*lib.IssueHistory{}
+		if _, err := os.Stat(*changelog); *incremental && err == nil {
+			old, err = lib.LoadChangelog(*changelog)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fetcher: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		histories, err := f.changelogs(jql)
+		if err == nil {
+			err = writeChangelog(*changelog, old, histories)
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "fetcher: parsing: %v\n", err)
+			fmt.Fprintf(os.Stderr, "fetcher: changelog: %v\n", err)
 			os.Exit(1)
 		}
-		for _, item := range rss.Items {
-			i := item
-			issues[item.Key] = &i
-		}
-		if len(rss.Items) < 100 {
-			break
-		}
-		start += 100
-	}
-	for _, item := range issues {
-		if item.Type == "Sub-task" {
-			if item.Parent == "" {
-				fmt.Fprintf(os.Stderr, "fetcher: empty parent %v\n", item.Key)
-			} else {
-				if parent, ok := issues[item.Parent]; ok {
-					item.Type = parent.Type
-				} else {
-					fmt.Fprintf(os.Stderr, "fetcher: parent not found %v\n", item.Parent)
-				}
-			}
+	}
+	keys := []string{}
+	for k := range f.issues {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keyLess(keys[i], keys[j]) })
+	orphans := []string{}
+	for _, k := range keys {
+		if err := f.resolve(f.issues[k]); err != nil {
+			orphans = append(orphans, fmt.Sprintf("%v: %v", k, err))
+		}
+	}
+	if *storeFile == "" {
+		err = writeIssues(os.Stdout, keys, f.issues)
+	} else {
+		var changes int
+		changes, err = st.recordTypeChanges(keys, stored, f.issues, started)
+		if err == nil {
+			err = st.save(keys, f.issues, t.project, started)
+		}
+		fmt.Fprintf(os.Stderr, "fetcher: %v issues fetched, %v stored, %v type changes\n",
+			len(items), len(keys), changes)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fetcher: writing: %v\n", err)
+		os.Exit(1)
+	}
+	if err := c.clear(); err != nil {
+		fmt.Fprintf(os.Stderr, "fetcher: %v\n", err)
+	}
+	if len(orphans) > 0 {
+		fmt.Fprintf(os.Stderr, "fetcher: %v of %v issues are sub-tasks of unknown type:\n",
+			len(orphans), len(keys))
+		for _, o := range orphans {
+			fmt.Fprintln(os.Stderr, "  "+o)
 		}
-		fmt.Printf("%v,%v\n", item.Key, item.Type)
 	}
 }
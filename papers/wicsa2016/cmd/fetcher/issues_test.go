@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"../../lib"
+)
+
+func TestIngestRecordedItem(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/OFBIZ-4512.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rss := Rss{}
+	if err := xml.Unmarshal(b, &rss); err != nil {
+		t.Fatal(err)
+	}
+	if len(rss.Items) != 1 {
+		t.Fatalf("%v items decoded, want 1", len(rss.Items))
+	}
+	item := rss.Items[0]
+	item.ingest()
+	want := "Saving a sales order whose ship group was removed throws: java.lang.NullPointerException " +
+		"at org.apache.ofbiz.order.shoppingcart.ShoppingCart.getShipGroup The order & its items are lost."
+	if item.Description != want {
+		t.Errorf("description %q, want %q", item.Description, want)
+	}
+	if item.Epic != "OFBIZ-4400" {
+		t.Errorf("epic %q, want OFBIZ-4400", item.Epic)
+	}
+	links := []lib.Link{{Kind: "duplicated-by", Target: "OFBIZ-4520"}}
+	if !reflect.DeepEqual(item.Links, links) {
+		t.Errorf("links %v, want %v", item.Links, links)
+	}
+	w := item.workItem()
+	if w.Description != want || w.Kind != "Bug" || w.OriginalKind != "Bug" {
+		t.Errorf("work item %+v", w)
+	}
+}
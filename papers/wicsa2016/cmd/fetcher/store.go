@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"../../lib"
+)
+
+// syncOverlap widens the incremental queries, as Jira compares the dates
+// in the time zone of the server and only to the minute. Fetching an issue
+// twice is harmless.
+const syncOverlap = 24 * time.Hour
+
+// store is an issues file kept current by incremental fetches. The time of
+// the last fetch is kept in <file>.sync and the type changes of the issues
+// are appended to <file>.history.
+type store struct {
+	file string
+}
+
+type syncState struct {
+	Project  string    `json:"project"`
+	LastSync time.Time `json:"lastSync"`
+}
+
+// load returns the issues of the store, none if it does not exist yet.
+func (s store) load() (map[string]*Item, error) {
+	issues := map[string]*Item{}
+	f, err := os.Open(s.file)
+	if os.IsNotExist(err) {
+		return issues, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := lib.NewTableReader(f, lib.JiraIssuesSchema)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", s.file, err)
+	}
+	for {
+		row, err := t.Read()
+		if err == io.EOF {
+			return issues, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%v: %v", s.file, err)
+		}
+		w, epic := lib.ParseJiraIssue(row)
+		if w.OriginalKind == "" {
+			w.OriginalKind = w.Kind
+		}
+		issues[w.Id] = &Item{Key: w.Id, Type: w.Kind, OriginalType: w.OriginalKind,
+			Title: w.Title, Description: w.Description, Parent: w.Parent, Epic: epic, Links: w.Links,
+			Components: w.Components, Labels: w.Labels}
+	}
+}
+
+// lastSync returns the time of the last fetch of the project, or the zero
+// time if the store was never fetched.
+func (s store) lastSync(project string) (time.Time, error) {
+	b, err := ioutil.ReadFile(s.file + ".sync")
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	var state syncState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return time.Time{}, fmt.Errorf("%v.sync: %v", s.file, err)
+	}
+	if state.Project != project {
+		return time.Time{}, fmt.Errorf("%v was fetched from %v, not %v", s.file, state.Project, project)
+	}
+	return state.LastSync, nil
+}
+
+// save replaces the issues of the store and records the time of the fetch.
+func (s store) save(keys []string, issues map[string]*Item, project string, synced time.Time) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = writeIssues(tmp, keys, issues)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.file); err != nil {
+		return err
+	}
+	b, err := json.Marshal(syncState{Project: project, LastSync: synced})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.file+".sync", b, 0644)
+}
+
+// recordTypeChanges appends the issues whose type differs from the one in
+// the store and returns how many they are.
+func (s store) recordTypeChanges(keys []string, old, issues map[string]*Item, at time.Time) (int, error) {
+	f, err := os.OpenFile(s.file+".history", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		w.Write([]string{"Time", "Key", "Old Type", "New Type"})
+	}
+	n := 0
+	for _, k := range keys {
+		if o, ok := old[k]; ok && o.Type != issues[k].Type {
+			w.Write([]string{at.UTC().Format(time.RFC3339), k, o.Type, issues[k].Type})
+			n++
+		}
+	}
+	w.Flush()
+	return n, w.Error()
+}
+
+func writeIssues(out io.Writer, keys []string, issues map[string]*Item) error {
+	w := csv.NewWriter(out)
+	w.Write(lib.JiraIssuesHeader())
+	for _, k := range keys {
+		w.Write(lib.FormatJiraIssue(issues[k].workItem(), issues[k].Epic))
+	}
+	w.Flush()
+	return w.Error()
+}
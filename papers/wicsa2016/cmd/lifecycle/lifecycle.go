@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"../../lib"
+)
+
+type measure struct {
+	name  string
+	value func(lib.IssueTimes) float64
+}
+
+func hours(known bool, d time.Duration) float64 {
+	if !known {
+		return math.NaN()
+	}
+	return d.Hours()
+}
+
+var measures = []measure{
+	{"LeadTimeHours", func(t lib.IssueTimes) float64 { return hours(t.Resolved, t.LeadTime) }},
+	{"CycleTimeHours", func(t lib.IssueTimes) float64 { return hours(t.Started, t.CycleTime) }},
+	{"HoursToFirstCommit", func(t lib.IssueTimes) float64 {
+		// commits made before the issue was filed count as zero
+		if t.TimeToFirstCommit < 0 {
+			t.TimeToFirstCommit = 0
+		}
+		return hours(t.Committed, t.TimeToFirstCommit)
+	}},
+	{"Reopens", func(t lib.IssueTimes) float64 { return float64(t.Reopens) }},
+}
+
+func main() {
+	var opts lib.SourceFlags
+	repository := flag.String("r", "ofbiz", "repository")
+	changelog := flag.String("l", "", "changelog file written by the fetcher")
+	started := flag.String("started", strings.Join(lib.DefaultLifecycle.Started, ","),
+		"statuses starting the work on an issue")
+	done := flag.String("done", strings.Join(lib.DefaultLifecycle.Done, ","),
+		"statuses ending the work on an issue")
+	out := flag.String("o", "", "file to write the measures of each issue to")
+	opts.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	opts.SetArgs(flag.Args())
+	if *changelog == "" {
+		log.Fatal("missing changelog file (-l)")
+	}
+	histories, err := lib.LoadChangelog(*changelog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	system, err := lib.LookupSystem(*repository)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, err := opts.Config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	commits, err := system.Source(opts.Options, config).Commits()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer commits.Close()
+	analyzer := lib.NewAnalyzer(system.Layers)
+	firstCommits := map[string]time.Time{}
+	for {
+		c, err := commits.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		analyzer.Add(c)
+		first, ok := firstCommits[c.Issue.Id]
+		if !ok || c.Change.ModifiedTime.Before(first) {
+			firstCommits[c.Issue.Id] = c.Change.ModifiedTime
+		}
+	}
+	lifecycle := lib.Lifecycle{Started: strings.Split(*started, ","), Done: strings.Split(*done, ",")}
+	times := []lib.IssueTimes{}
+	for id, g := range analyzer.Issues() {
+		h := histories[id]
+		if id == "" || h == nil {
+			continue
+		}
+		t := lifecycle.Times(h, firstCommits[id])
+		t.Layers = len(g.Layers)
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Key < times[j].Key })
+	if *out != "" {
+		if err := writeTimes(*out, times); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Println("Issues:", len(times))
+	reopened := 0
+	for _, t := range times {
+		if t.Reopens > 0 {
+			reopened++
+		}
+	}
+	fmt.Println("Reopened:", reopened)
+	for _, m := range measures {
+		byLayers := map[int][]float64{}
+		x, y := []float64{}, []float64{}
+		for _, t := range times {
+			v := m.value(t)
+			if math.IsNaN(v) {
+				continue
+			}
+			byLayers[t.Layers] = append(byLayers[t.Layers], v)
+			x = append(x, float64(t.Layers))
+			y = append(y, v)
+		}
+		fmt.Printf("%v: n=%v median=%.1f spearman(layers)=%.3f\n", m.name, len(y), lib.Median(y),
+			lib.Spearman(x, y))
+		layers := []int{}
+		for l := range byLayers {
+			layers = append(layers, l)
+		}
+		sort.Ints(layers)
+		for _, l := range layers {
+			fmt.Printf("  %v layers: n=%v median=%.1f\n", l, len(byLayers[l]), lib.Median(byLayers[l]))
+		}
+	}
+}
+
+func writeTimes(file string, times []lib.IssueTimes) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(f, "Key\tLayers")
+	for _, m := range measures {
+		fmt.Fprint(f, "\t", m.name)
+	}
+	fmt.Fprintln(f)
+	for _, t := range times {
+		fmt.Fprintf(f, "%v\t%v", t.Key, t.Layers)
+		for _, m := range measures {
+			fmt.Fprintf(f, "\t%.2f", m.value(t))
+		}
+		fmt.Fprintln(f)
+	}
+	return f.Close()
+}
@@ -1,38 +1,69 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path"
 	"time"
+
+	"../../lib"
 )
 
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Fprintf(os.Stderr, "usage: siop-log <output-dir> <start-YYYY/MM> <end-YYYY/MM>\n")
+	baseURL := flag.String("url", "", "RTC server URL (e.g. https://host/ccm); lscm is used if empty")
+	user := flag.String("user", "", "RTC user, whose password is read from $RTC_PASSWORD")
+	var runnerFlags lib.RunnerFlags
+	runnerFlags.Register(flag.CommandLine)
+	flag.Parse()
+	if flag.NArg() < 3 {
+		fmt.Fprintf(os.Stderr, "usage: siop-log [-url server -user name] <output-dir> <start-YYYY/MM> <end-YYYY/MM>\n")
 		os.Exit(1)
 	}
-	date, err := time.Parse("2006/01", os.Args[2])
+	date, err := time.Parse("2006/01", flag.Arg(1))
 	if err != nil {
 		log.Fatal(err)
 	}
-	end, err := time.Parse("2006/01", os.Args[3])
+	end, err := time.Parse("2006/01", flag.Arg(2))
 	if err != nil {
 		log.Fatal(err)
 	}
+	runner := runnerFlags.Runner()
+	var client *lib.RTCClient
+	if *baseURL != "" {
+		client = &lib.RTCClient{BaseURL: *baseURL, User: *user, Password: os.Getenv("RTC_PASSWORD")}
+		if err := client.Login(); err != nil {
+			log.Fatal(err)
+		}
+	}
 	end.AddDate(0, 1, 0)
 	//date := time.Date(2008, time.January, 1, 0, 0, 0, 0, time.Local)
 	for !date.After(end) {
-		cmd := exec.Command("lscm", "list", "changesets", "-r", "siop",
-			"--created-after", date.AddDate(0, 0, -1).Format("2006/01/02"),
-			"--created-before", date.AddDate(0, 1, 0).Format("2006/01/02"), "-m", "10000", "-j")
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			log.Fatal(err, string(out))
+		after, before := date.AddDate(0, 0, -1), date.AddDate(0, 1, 0)
+		var out []byte
+		if client != nil {
+			changesets, err := client.Changesets(after, before)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if out, err = json.Marshal(changesets); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(*baseURL, after.Format("2006/01/02"), before.Format("2006/01/02"))
+		} else {
+			args := []string{"list", "changesets", "-r", "siop",
+				"--created-after", after.Format("2006/01/02"),
+				"--created-before", before.Format("2006/01/02"), "-m", "10000", "-j"}
+			out, err = runner.Output(context.Background(), "", "lscm", args...)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(append([]string{"lscm"}, args...))
 		}
-		f, err := os.Create(path.Join(os.Args[1],
+		f, err := os.Create(path.Join(flag.Arg(0),
 			fmt.Sprintf("siop-changesets-%v-%v.json", date.Year(), date.Month())))
 		if err != nil {
 			log.Fatal(err)
@@ -43,6 +74,5 @@ func main() {
 		}
 		f.Close()
 		date = date.AddDate(0, 1, 0)
-		fmt.Println(cmd.Args)
 	}
 }
@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type AuthorCount struct {
+	Name  string
+	Count int
+}
+
+type Activity struct {
+	Commits              int
+	FilesPerCommit       float64
+	HoursBetweenCommits  float64
+	Authors              []AuthorCount
+	Sessions             int
+	CommitsPerSession    float64
+	FilesPerSession      float64
+	HoursPerSession      float64
+	HoursBetweenSessions float64
+}
+
+// ActivityAnalyzer computes the mean size of and interval between commits,
+// which must be added in modification time order (see SortCommits), and of
+// the change sessions they form when SessionGap is not zero. As in the stats
+// of the separate and exclude unlinked policies, commits without issue form
+// no sessions.
+type ActivityAnalyzer struct {
+	SessionGap     time.Duration
+	sessions       *Sessionizer
+	closed         sessionTotals
+	commits        int
+	files          int
+	totalIntervals float64
+	lastTime       time.Time
+	authors        map[string]int
+}
+
+func NewActivityAnalyzer() *ActivityAnalyzer {
+	return &ActivityAnalyzer{authors: map[string]int{}}
+}
+
+func (a *ActivityAnalyzer) Add(c *Commit) error {
+	if a.commits > 0 {
+		if c.Change.ModifiedTime.Before(a.lastTime) {
+			return fmt.Errorf("commit %v out of order: %v before %v", c.Change.Uuid,
+				c.Change.ModifiedTime, a.lastTime)
+		}
+		a.totalIntervals += c.Change.ModifiedTime.Sub(a.lastTime).Hours()
+	}
+	a.lastTime = c.Change.ModifiedTime
+	if a.SessionGap > 0 && c.Issue.Id != "" {
+		if a.sessions == nil {
+			a.sessions = NewSessionizer(a.SessionGap, a.closed.add)
+		}
+		a.sessions.Add(c)
+	}
+	a.commits++
+	a.files += len(c.Files)
+	a.authors[c.Change.Author]++
+	return nil
+}
+
+func (a *ActivityAnalyzer) Activity() *Activity {
+	result := &Activity{
+		Commits:             a.commits,
+		FilesPerCommit:      ratio(float64(a.files), float64(a.commits)),
+		HoursBetweenCommits: ratio(a.totalIntervals, float64(a.commits-1)),
+		Authors:             make([]AuthorCount, 0, len(a.authors))}
+	for k, v := range a.authors {
+		result.Authors = append(result.Authors, AuthorCount{k, v})
+	}
+	sort.Sort(byCount(result.Authors))
+	if a.sessions != nil {
+		totals := a.closed
+		for _, s := range a.sessions.Open() {
+			totals.add(s)
+		}
+		n := float64(totals.sessions)
+		result.Sessions = totals.sessions
+		result.CommitsPerSession = ratio(float64(totals.commits), n)
+		result.FilesPerSession = ratio(float64(totals.files), n)
+		result.HoursPerSession = ratio(totals.hours, n)
+		// the mean of the intervals between consecutive starts
+		result.HoursBetweenSessions = ratio(totals.lastStart.Sub(totals.firstStart).Hours(), n-1)
+	}
+	return result
+}
+
+// sessionTotals sums up the change sessions.
+type sessionTotals struct {
+	sessions   int
+	commits    int
+	files      int
+	hours      float64
+	firstStart time.Time
+	lastStart  time.Time
+}
+
+func (t *sessionTotals) add(s *Session) {
+	if t.sessions == 0 || s.Start.Before(t.firstStart) {
+		t.firstStart = s.Start
+	}
+	if t.sessions == 0 || s.Start.After(t.lastStart) {
+		t.lastStart = s.Start
+	}
+	t.sessions++
+	t.commits += s.Commits
+	t.files += len(s.Files)
+	t.hours += s.End.Sub(s.Start).Hours()
+}
+
+// ratio returns the mean a/n, 0 when there is nothing to average, such as
+// the intervals between a single commit.
+func ratio(a, n float64) float64 {
+	if n <= 0 {
+		return 0
+	}
+	return a / n
+}
+
+type byCount []AuthorCount
+
+func (arr byCount) Len() int { return len(arr) }
+func (arr byCount) Less(i, j int) bool {
+	return arr[i].Count < arr[j].Count
+}
+func (arr byCount) Swap(i, j int) { arr[i], arr[j] = arr[j], arr[i] }
@@ -0,0 +1,313 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cache stores the commits extracted from git repositories, one pair of
+// files per repository: <key>.meta describes the entry and <key>.commits
+// holds the commits, one JSON object per line, in log order. Runs on the
+// same repository take turns through the lock of <key>.lock.
+type Cache struct {
+	Dir string
+}
+
+// cacheFormat is the version of the commits cached, entries of earlier ones
+// being rebuilt: 2 records the parents of the commits and lists the files of
+// merges against their first parent, 3 records their committer dates and 4
+// their committers and co-authors.
+const cacheFormat = 4
+
+type CacheEntry struct {
+	Format     int
+	Repository string
+	Head       string
+	Commits    int
+	Size       int64
+	Updated    time.Time
+}
+
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "wicsa2016")
+}
+
+func (c *Cache) paths(repository string) (meta, commits, lock string, err error) {
+	abs, err := filepath.Abs(repository)
+	if err != nil {
+		return "", "", "", err
+	}
+	sum := sha1.Sum([]byte(abs))
+	key := filepath.Join(c.Dir, hex.EncodeToString(sum[:]))
+	return key + ".meta", key + ".commits", key + ".lock", nil
+}
+
+func (c *Cache) Entry(repository string) (*CacheEntry, error) {
+	meta, _, _, err := c.paths(repository)
+	if err != nil {
+		return nil, err
+	}
+	return readCacheEntry(meta)
+}
+
+func readCacheEntry(meta string) (*CacheEntry, error) {
+	f, err := os.Open(meta)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	e := &CacheEntry{}
+	if err := json.NewDecoder(f).Decode(e); err != nil {
+		return nil, fmt.Errorf("error decoding cache entry %v: %v", meta, err)
+	}
+	return e, nil
+}
+
+func (c *Cache) Entries() ([]*CacheEntry, error) {
+	metas, err := filepath.Glob(filepath.Join(c.Dir, "*.meta"))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*CacheEntry, 0, len(metas))
+	for _, meta := range metas {
+		e, err := readCacheEntry(meta)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Repository < entries[j].Repository
+	})
+	return entries, nil
+}
+
+// Clear removes the entry of repository, or every entry if it is empty,
+// waiting for the runs using them. The lock files are kept, as removing one
+// would let two runs lock different files.
+func (c *Cache) Clear(repository string) error {
+	keys := []string{}
+	if repository == "" {
+		all, err := filepath.Glob(filepath.Join(c.Dir, "*"))
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		for _, f := range all {
+			key := strings.TrimSuffix(strings.TrimSuffix(f, ".meta"), ".commits")
+			if key != f && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	} else {
+		meta, _, _, err := c.paths(repository)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, strings.TrimSuffix(meta, ".meta"))
+	}
+	for _, key := range keys {
+		if err := clearEntry(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func clearEntry(key string) error {
+	unlock, err := lockFile(key + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, f := range []string{key + ".meta", key + ".commits"} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cache) writeEntry(meta string, e *CacheEntry) error {
+	tmp := meta + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(e)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, meta)
+}
+
+// open serves the cached commits of s followed by the ones added to the
+// repository since, which are appended to the cache as they are read. The
+// entry is rebuilt when the cached head is no longer in the history. The
+// entry is locked until the reader is closed.
+func (c *Cache) open(s *GitSource, link func(*Commit) bool) (CommitReader, error) {
+	head, err := s.head()
+	if err != nil {
+		return nil, err
+	}
+	meta, commits, lock, err := c.paths(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return nil, err
+	}
+	unlock, err := lockFile(lock)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.read(s, link, head, meta, commits)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	r.unlock = unlock
+	return r, nil
+}
+
+func (c *Cache) read(s *GitSource, link func(*Commit) bool, head, meta, commits string) (*cacheReader, error) {
+	entry, err := readCacheEntry(meta)
+	if err != nil || entry.Format != cacheFormat || (entry.Head != head && !s.isAncestor(entry.Head)) {
+		abs, _ := filepath.Abs(s.Dir)
+		entry = &CacheEntry{Format: cacheFormat, Repository: abs}
+	}
+	out, err := os.OpenFile(commits, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := out.Truncate(entry.Size); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if _, err := out.Seek(entry.Size, io.SeekStart); err != nil {
+		out.Close()
+		return nil, err
+	}
+	in, err := os.Open(commits)
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	r := &cacheReader{cache: c, source: s, meta: meta, entry: entry, head: head, link: link,
+		in: in, cached: json.NewDecoder(io.LimitReader(in, entry.Size)),
+		out: out, writer: bufio.NewWriter(out)}
+	if entry.Head != head {
+		rev := "HEAD"
+		if entry.Head != "" {
+			rev = entry.Head + "..HEAD"
+		}
+		if r.git, err = s.log(rev, nil); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+type cacheReader struct {
+	cache  *Cache
+	source *GitSource
+	meta   string
+	entry  *CacheEntry
+	head   string
+	link   func(*Commit) bool
+	in     *os.File
+	cached *json.Decoder
+	git    *gitCommitReader
+	out    *os.File
+	writer *bufio.Writer
+	unlock func() error
+}
+
+func (r *cacheReader) Read() (*Commit, error) {
+	for r.cached.More() {
+		c := &Commit{}
+		if err := r.cached.Decode(c); err != nil {
+			return nil, fmt.Errorf("error decoding cached commits of %v: %v",
+				r.entry.Repository, err)
+		}
+		if !r.link(c) {
+			continue
+		}
+		if c.isMerge() && c.Files == nil {
+			// cached by a run that did not collapse merges
+			var err error
+			if c.Files, err = r.source.files(context.Background(), c.Change); err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	}
+	for r.git != nil {
+		c, err := r.git.Read()
+		if err == io.EOF {
+			if err := r.commit(); err != nil {
+				return nil, err
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line, err := json.Marshal(&Commit{Change: c.Change, Files: c.Files})
+		if err != nil {
+			return nil, err
+		}
+		r.writer.Write(line)
+		if err := r.writer.WriteByte('\n'); err != nil {
+			return nil, err
+		}
+		r.entry.Size += int64(len(line)) + 1
+		r.entry.Commits++
+		if r.link(c) {
+			return c, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// commit records the appended commits once the log has been fully read.
+func (r *cacheReader) commit() error {
+	r.git = nil
+	if err := r.writer.Flush(); err != nil {
+		return err
+	}
+	r.entry.Head = r.head
+	r.entry.Updated = time.Now()
+	return r.cache.writeEntry(r.meta, r.entry)
+}
+
+func (r *cacheReader) Close() error {
+	if r.git != nil {
+		r.git.Close()
+	}
+	r.in.Close()
+	err := r.out.Close()
+	if r.unlock != nil {
+		r.unlock()
+		r.unlock = nil
+	}
+	return err
+}
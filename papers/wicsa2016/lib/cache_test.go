@@ -0,0 +1,219 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The recordings of testdata/cache were made with -record by stats on a
+// sample OFBiz history sharing one cache between three runs: 1 with three
+// commits, 2 after two more commits and 3 after a rewrite of the last three
+// into a single one.
+var cacheStates = map[string][]string{
+	"1": {"initial import", "OFBIZ-1 add invoice entity", "OFBIZ-2 order screen"},
+	"2": {"initial import", "OFBIZ-1 add invoice entity", "OFBIZ-2 order screen", "OFBIZ-3 fix build",
+		"OFBIZ-2 order service"},
+	"3": {"initial import", "OFBIZ-1 add invoice entity", "OFBIZ-2 order screen, reworked"},
+}
+
+func cacheSource(dir, state string) (*GitSource, *loggingRunner) {
+	runner := &loggingRunner{Replayer: Replayer{Dir: filepath.Join("testdata", "cache", state)}}
+	opts := Options{RepoPath: filepath.Join("testdata", "cache", "ofbiz"),
+		IssuesFile: filepath.Join("testdata", "cache", "issues.csv"), FeatureBy: "epic,component"}
+	config := Config{Policies: Policies{Merges: KeepMerges},
+		Extraction: Extraction{Workers: 2, CacheDir: dir, Runner: runner}}
+	system, _ := LookupSystem("ofbiz")
+	return system.Source(opts, config).(*GitSource), runner
+}
+
+// readCached reads the commits of state through the cache of dir, checking
+// their subjects, and returns the commands run.
+func readCached(t *testing.T, dir, state string) []string {
+	s, runner := cacheSource(dir, state)
+	r, err := s.Commits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, err := ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subjects := []string{}
+	for _, c := range commits {
+		subjects = append(subjects, c.Change.Comment)
+	}
+	if !reflect.DeepEqual(subjects, cacheStates[state]) {
+		t.Errorf("state %v: commits %q, want %q", state, subjects, cacheStates[state])
+	}
+	entry, err := s.Cache.Entry(s.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Commits != len(cacheStates[state]) || entry.Format != cacheFormat {
+		t.Errorf("state %v: entry %+v", state, entry)
+	}
+	return runner.commands
+}
+
+// logged returns the revision ranges logged and the number of files listed.
+func logged(commands []string) (revs []string, listed int) {
+	for _, cmd := range commands {
+		switch {
+		case strings.HasPrefix(cmd, "git --no-pager log"):
+			revs = append(revs, cmd[strings.LastIndex(cmd, " ")+1:])
+		case strings.HasPrefix(cmd, "git diff-tree"):
+			listed++
+		}
+	}
+	return revs, listed
+}
+
+func TestCacheExtendsIncrementally(t *testing.T) {
+	dir := t.TempDir()
+	if revs, listed := logged(readCached(t, dir, "1")); !reflect.DeepEqual(revs, []string{"HEAD"}) || listed != 3 {
+		t.Errorf("first run logged %v and listed %v commits, want HEAD and 3", revs, listed)
+	}
+	revs, listed := logged(readCached(t, dir, "2"))
+	if len(revs) != 1 || !strings.HasSuffix(revs[0], "..HEAD") || listed != 2 {
+		t.Errorf("second run logged %v and listed %v commits, want <old head>..HEAD and 2", revs, listed)
+	}
+	if revs, listed := logged(readCached(t, dir, "2")); len(revs) != 0 || listed != 0 {
+		t.Errorf("third run logged %v and listed %v commits, want none", revs, listed)
+	}
+}
+
+func TestCacheRebuildsRewrittenHistory(t *testing.T) {
+	dir := t.TempDir()
+	readCached(t, dir, "1")
+	readCached(t, dir, "2")
+	if revs, listed := logged(readCached(t, dir, "3")); !reflect.DeepEqual(revs, []string{"HEAD"}) || listed != 3 {
+		t.Errorf("run after the rewrite logged %v and listed %v commits, want HEAD and 3", revs, listed)
+	}
+}
+
+func TestCacheRebuildsOlderFormats(t *testing.T) {
+	dir := t.TempDir()
+	readCached(t, dir, "1")
+	s, _ := cacheSource(dir, "1")
+	meta, _, _, _ := s.Cache.paths(s.Dir)
+	entry, _ := readCacheEntry(meta)
+	entry.Format = cacheFormat - 1
+	if err := s.Cache.writeEntry(meta, entry); err != nil {
+		t.Fatal(err)
+	}
+	if revs, listed := logged(readCached(t, dir, "1")); !reflect.DeepEqual(revs, []string{"HEAD"}) || listed != 3 {
+		t.Errorf("run on format %v logged %v and listed %v commits, want HEAD and 3", entry.Format,
+			revs, listed)
+	}
+}
+
+func TestCacheTruncatesInterruptedRuns(t *testing.T) {
+	dir := t.TempDir()
+	readCached(t, dir, "1")
+	// a run killed while appending a line
+	s, _ := cacheSource(dir, "1")
+	_, commits, _, _ := s.Cache.paths(s.Dir)
+	f, err := os.OpenFile(commits, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Feature":"","Change":{"author":"Ali`)
+	f.Close()
+	// a run stopped before reading the whole log
+	s, _ = cacheSource(dir, "2")
+	r, err := s.Commits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := r.Read(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+	if entry, _ := s.Cache.Entry(s.Dir); entry.Commits != 3 {
+		t.Errorf("interrupted run recorded %v commits, want the 3 of the first", entry.Commits)
+	}
+	revs, listed := logged(readCached(t, dir, "2"))
+	if len(revs) != 1 || !strings.HasSuffix(revs[0], "..HEAD") || listed != 2 {
+		t.Errorf("run after the interrupted ones logged %v and listed %v commits, want "+
+			"<old head>..HEAD and 2", revs, listed)
+	}
+	b, err := ioutil.ReadFile(commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("%v cached lines, want 5", len(lines))
+	}
+	for _, line := range lines {
+		if err := json.Unmarshal([]byte(line), &Commit{}); err != nil {
+			t.Errorf("cached line %q: %v", line, err)
+		}
+	}
+}
+
+func TestCacheLocksEntries(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := cacheSource(dir, "1")
+	first, err := s.Commits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		s, _ := cacheSource(dir, "1")
+		r, err := s.Commits()
+		if err == nil {
+			_, err = ReadAll(r)
+			r.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("second run did not wait for the first: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := ReadAll(first); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second run still waiting after the first closed")
+	}
+	if entry, err := s.Cache.Entry(s.Dir); err != nil || entry.Commits != 3 {
+		t.Errorf("entry after both runs: %+v, %v", entry, err)
+	}
+}
+
+func TestCacheClear(t *testing.T) {
+	dir := t.TempDir()
+	readCached(t, dir, "1")
+	c := &Cache{Dir: dir}
+	if entries, err := c.Entries(); err != nil || len(entries) != 1 {
+		t.Fatalf("entries = %v, %v", entries, err)
+	}
+	if err := c.Clear(""); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := c.Entries(); err != nil || len(entries) != 0 {
+		t.Errorf("entries after clearing = %v, %v", entries, err)
+	}
+	if revs, _ := logged(readCached(t, dir, "1")); !reflect.DeepEqual(revs, []string{"HEAD"}) {
+		t.Errorf("run after clearing logged %v, want HEAD", revs)
+	}
+}
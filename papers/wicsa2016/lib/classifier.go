@@ -0,0 +1,181 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// NaiveBayes is a multinomial naive Bayes classifier of bags of tokens with
+// Laplace smoothing. It is saved as JSON so a model trained once can be used
+// offline.
+type NaiveBayes struct {
+	Docs   map[string]int            `json:"docs"`
+	Tokens map[string]map[string]int `json:"tokens"`
+	Totals map[string]int            `json:"totals"`
+}
+
+func NewNaiveBayes() *NaiveBayes {
+	return &NaiveBayes{Docs: map[string]int{}, Tokens: map[string]map[string]int{},
+		Totals: map[string]int{}}
+}
+
+func LoadNaiveBayes(file string) (*NaiveBayes, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading model: %v", err)
+	}
+	nb := NewNaiveBayes()
+	if err := json.Unmarshal(b, nb); err != nil {
+		return nil, fmt.Errorf("error decoding model %v: %v", file, err)
+	}
+	return nb, nil
+}
+
+func (nb *NaiveBayes) Save(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(nb)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+func (nb *NaiveBayes) Train(class string, tokens []string) {
+	nb.Docs[class]++
+	if nb.Tokens[class] == nil {
+		nb.Tokens[class] = map[string]int{}
+	}
+	for _, t := range tokens {
+		nb.Tokens[class][t]++
+		nb.Totals[class]++
+	}
+}
+
+// Classify returns the most probable class of the tokens and its posterior
+// probability, or "" if the model was not trained.
+func (nb *NaiveBayes) Classify(tokens []string) (string, float64) {
+	docs := 0
+	vocabulary := map[string]bool{}
+	classes := make([]string, 0, len(nb.Docs))
+	for c, n := range nb.Docs {
+		docs += n
+		classes = append(classes, c)
+		for t := range nb.Tokens[c] {
+			vocabulary[t] = true
+		}
+	}
+	sort.Strings(classes)
+	logs := make([]float64, len(classes))
+	best := -1
+	for i, c := range classes {
+		logs[i] = math.Log(float64(nb.Docs[c]) / float64(docs))
+		for _, t := range tokens {
+			if !vocabulary[t] {
+				continue
+			}
+			logs[i] += math.Log(float64(nb.Tokens[c][t]+1) / float64(nb.Totals[c]+len(vocabulary)))
+		}
+		if best < 0 || logs[i] > logs[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", 0
+	}
+	sum := 0.0
+	for _, l := range logs {
+		sum += math.Exp(l - logs[best])
+	}
+	return classes[best], 1 / sum
+}
+
+var stopWords = map[string]bool{"the": true, "and": true, "for": true, "with": true,
+	"from": true, "that": true, "this": true, "are": true, "not": true, "when": true,
+	"into": true, "should": true, "can": true, "has": true, "have": true, "use": true}
+
+// Words returns the lower case words of text, less the short and the stop
+// words.
+func Words(text string) []string {
+	words := []string{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		if len(w) > 2 && !stopWords[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// IssueFeatures returns the tokens describing an issue to the classifier:
+// the words of its title and description and, if it has commits, their
+// number, size and layers.
+func IssueFeatures(title, description string, commits *Group) []string {
+	tokens := append(Words(title), Words(description)...)
+	if commits == nil {
+		return append(tokens, "commits:0")
+	}
+	return append(tokens, "commits:"+bucket(commits.Commits),
+		"files:"+bucket(commits.Files), "layers:"+strconv.Itoa(len(commits.Layers)))
+}
+
+func bucket(n int) string {
+	switch {
+	case n <= 1:
+		return strconv.Itoa(n)
+	case n <= 5:
+		return "2-5"
+	case n <= 20:
+		return "6-20"
+	}
+	return ">20"
+}
+
+// LabeledIssuesSchema describes the manual classification of issues the
+// classifier is trained on, e.g. the data set of Herzig et al.
+var LabeledIssuesSchema = Schema{Name: "labeled issues", Columns: []Column{
+	{Name: "Key", Aliases: []string{"ID", "Issue"}, Required: true},
+	{Name: "Kind", Aliases: []string{"Classification", "Type", "Label"}, Required: true},
+	{Name: "Title", Aliases: []string{"Summary"}},
+	{Name: "Description", Aliases: []string{"Body", "Text"}}}}
+
+// CorrectionsSchema describes the issue kinds corrected by classify.
+var CorrectionsSchema = Schema{Name: "corrections", Columns: []Column{
+	{Name: "Key", Required: true},
+	{Name: "Type", Required: true},
+	{Name: "Corrected Type", Required: true},
+	{Name: "Probability"}}}
+
+// LoadCorrections returns the corrected kind of each issue of file.
+func LoadCorrections(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening corrections file: %v", err)
+	}
+	defer f.Close()
+	t, err := NewTableReader(f, CorrectionsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	corrections := map[string]string{}
+	for {
+		row, err := t.Read()
+		if err == io.EOF {
+			return corrections, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+		corrections[row.Get("Key")] = row.Get("Corrected Type")
+	}
+}
@@ -1,169 +1,308 @@
 package lib
 
 import (
-	"bufio"
-	"encoding/csv"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"log"
 	"os"
-	"os/exec"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"time"
 )
 
-type Functions struct {
-	Commits        func([]string, func(string) string) ([]*Commit, error)
-	IssueExtractor func(string) string
-	LayerExtractor func(string) string
+// Options are the inputs of a commit source, its repository and issues file
+// or its commits file, and the filters of the commits read.
+type Options struct {
+	RepoPath    string
+	IssuesFile  string
+	CommitsFile string
+	IssueKind   string
+	IssuesOnly  bool
+	FeatureBy   string
 }
 
-var mapCommitsFunctions = map[string]Functions{
-	"siop": {
-		Commits:        commitsFromSiop,
-		LayerExtractor: siopLayerExtractor},
-	"ofbiz": {
-		Commits:        commitsFromGitAndJira,
-		IssueExtractor: ofbizIssueExtractor,
-		LayerExtractor: ofbizLayerExtractor},
-	"openmrs": {
-		Commits:        commitsFromGitAndJira,
-		IssueExtractor: openmrsIssueExtractor,
-		LayerExtractor: openmrsLayerExtractor},
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.RepoPath, "g", "", "git repository path")
+	fs.StringVar(&o.IssuesFile, "j", "", "issues file")
+	fs.StringVar(&o.CommitsFile, "c", "", "commits file")
+	fs.StringVar(&o.FeatureBy, "feature", "epic,component",
+		"sources of the features of Jira issues, tried in order: epic, parent, component, label")
 }
 
-func CommitsFunctions(key string) Functions {
-	return mapCommitsFunctions[key]
+// SetArgs fills the inputs not given as flags from the positional arguments:
+// <commits file> for siop, <git repo> <issues file> for git based systems. A
+// single directory is taken as the git repository.
+func (o *Options) SetArgs(args []string) {
+	switch len(args) {
+	case 1:
+		if fi, err := os.Stat(args[0]); err == nil && fi.IsDir() {
+			if o.RepoPath == "" {
+				o.RepoPath = args[0]
+			}
+		} else if o.CommitsFile == "" {
+			o.CommitsFile = args[0]
+		}
+	case 2:
+		if o.RepoPath == "" {
+			o.RepoPath = args[0]
+		}
+		if o.IssuesFile == "" {
+			o.IssuesFile = args[1]
+		}
+	}
 }
 
-func commitsFromSiop(args []string, _ func(string) string) ([]*Commit, error) {
-	if len(os.Args) < 2 {
-		return nil, fmt.Errorf("usage: stats <commits file>")
+func (o Options) keep(c *Commit) bool {
+	if o.IssueKind != "" && c.Issue.Kind != o.IssueKind {
+		return false
 	}
-	file, err := os.Open(args[len(args)-1])
-	if err != nil {
-		return nil, fmt.Errorf("error opening file: %v %v", args[len(args)-1], err)
+	if o.IssuesOnly && c.Issue.Id == "" {
+		return false
+	}
+	return true
+}
+
+// Config holds the settings of a commit source besides its Options, each
+// group resolved: the zones loaded and the corrections read.
+type Config struct {
+	Policies   Policies
+	Zones      Zones
+	Extraction Extraction
+	// Corrected maps the issues whose kind was corrected by classify to
+	// their corrected kind.
+	Corrected map[string]string
+}
+
+// SourceFlags are the flags of the commands reading commits, resolved into
+// the Config of their source by Config.
+type SourceFlags struct {
+	Options
+	Policies
+	Extraction
+	Zone        string
+	Normalize   string
+	Corrections string
+}
+
+func (f *SourceFlags) RegisterFlags(fs *flag.FlagSet) {
+	f.Options.RegisterFlags(fs)
+	fs.StringVar(&f.Corrections, "corrected", "",
+		"issue kinds corrected by classify (empty uses the kinds of the tracker)")
+	f.Policies.RegisterFlags(fs)
+	fs.StringVar(&f.Zone, "zone", DefaultZone, "time zone of the commit timestamps recorded without one, such as SIOP's")
+	fs.StringVar(&f.Normalize, "normalize", "",
+		"time zone to convert the commit timestamps to (empty keeps the zones they were recorded in)")
+	f.Extraction.RegisterFlags(fs)
+}
+
+// Config checks the policies, loads the zones and reads the corrections.
+func (f *SourceFlags) Config() (Config, error) {
+	if err := f.Policies.check(); err != nil {
+		return Config{}, err
 	}
-	commits := []*Commit{}
-	json.NewDecoder(file).Decode(&commits)
-	err = file.Close()
+	zones, err := LoadZones(f.Zone, f.Normalize)
 	if err != nil {
-		return nil, err
+		return Config{}, err
 	}
-	for _, c := range commits {
-		modified, err := time.Parse("02/01/2006 15:04", c.Change.Modified)
-		if err != nil {
-			return nil, err
+	c := Config{Policies: f.Policies, Zones: zones, Extraction: f.Extraction}
+	if f.Corrections != "" {
+		if c.Corrected, err = LoadCorrections(f.Corrections); err != nil {
+			return Config{}, err
 		}
-		c.Change.ModifiedTime = modified
 	}
-	return commits, nil
+	return c, nil
 }
 
-func commitsFromGitAndJira(args []string,
-	issueExtractor func(string) string) ([]*Commit, error) {
-	if len(os.Args) < 5 {
-		return nil, fmt.Errorf("usage: stats <git repo> <issues file>")
+// DefaultZone is the zone of the timestamps recorded without one, the same
+// for every command so that they read the SIOP exports alike.
+const DefaultZone = "UTC"
+
+// Zones are the time zones of the commit timestamps: Recorded is the zone of
+// the ones recorded without one, and Reporting, if not nil, the zone they are
+// converted to.
+type Zones struct {
+	Recorded  *time.Location
+	Reporting *time.Location
+}
+
+// LoadZones loads the zones named recorded and reporting, either of which
+// may be empty.
+func LoadZones(recorded, reporting string) (Zones, error) {
+	var z Zones
+	var err error
+	if recorded != "" {
+		if z.Recorded, err = time.LoadLocation(recorded); err != nil {
+			return Zones{}, err
+		}
 	}
-	issues, err := os.Open(args[len(args)-1])
-	if err != nil {
-		return nil, err
-	}
-	r := csv.NewReader(issues)
-	issuesMap := map[string]string{}
-	for {
-		record, err := r.Read()
-		if err == io.EOF {
-			break
-		} else if err != nil && err != io.EOF {
-			log.Fatal("Error reading issues file ", err)
+	if reporting != "" {
+		if z.Reporting, err = time.LoadLocation(reporting); err != nil {
+			return Zones{}, err
 		}
-		issuesMap[record[0]] = record[1]
 	}
-	cmd := exec.Command("git", "--no-pager", "log", "--date=iso", "--reverse",
-		"--pretty=format:%H%x09%an%x09%ad%x09%s")
-	cmd.Dir = args[len(args)-2]
-	stdout, err := cmd.StdoutPipe()
+	return z, nil
+}
+
+// parseTimes parses the timestamps of the commits files: RFC 3339 ones, and
+// the zone-less "02/01/2006 15:04" ones of SIOP, in Recorded. Changes without
+// a committer timestamp were committed by their author.
+func (z Zones) parseTimes(c *Change) error {
+	parser := TimestampParser{Location: z.Recorded, Order: DayFirst}
+	modified, err := parser.Parse(c.Modified)
 	if err != nil {
-		return nil, err
-	}
-	if err := cmd.Start(); err != nil {
-		return nil, err
-	}
-	commits := []*Commit{}
-	scan := bufio.NewScanner(stdout)
-	for scan.Scan() {
-		arr := strings.Split(scan.Text(), "\t")
-		issue := issueExtractor(arr[3])
-		kind := issuesMap[issue]
-		if kind != "Bug" {
-			kind = "Improvement"
-		}
-		cmdTree := exec.Command("git", "diff-tree", "--no-commit-id", "--name-only", "-r", arr[0])
-		cmdTree.Dir = args[len(args)-2]
-		outTree, err := cmdTree.CombinedOutput()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, string(outTree))
-			return nil, err
-		}
-		files := strings.Split(string(outTree), "\n")
-		if len(files) > 0 && files[len(files)-1] == "" {
-			files = files[:len(files)-1]
-		}
-		modified, err := time.Parse("2006-01-02 15:04:05 -0700", arr[2])
-		if err != nil {
-			return nil, err
-		}
-		commit := &Commit{
-			Change: &Change{
-				Uuid:         arr[0],
-				Author:       arr[1],
-				Comment:      arr[3],
-				Modified:     arr[2],
-				ModifiedTime: modified,
-			},
-			Issue: Issue{Id: issue, Kind: kind},
-			Files: files,
+		return err
+	}
+	c.ModifiedTime, c.CommittedTime = modified, modified
+	if c.Committed != "" {
+		if c.CommittedTime, err = parser.Parse(c.Committed); err != nil {
+			return err
 		}
-		commits = append(commits, commit)
 	}
-	if err := scan.Err(); err != nil {
-		return nil, err
+	return nil
+}
+
+// normalize converts the timestamps of c to the Reporting zone, if any.
+func (z Zones) normalize(c *Commit) {
+	if z.Reporting != nil {
+		c.Change.ModifiedTime = c.Change.ModifiedTime.In(z.Reporting)
+		c.Change.CommittedTime = c.Change.CommittedTime.In(z.Reporting)
+	}
+}
+
+// Extraction sets how the commits are extracted from git: through the cache
+// of CacheDir, if any, by Workers workers, running the commands with Runner,
+// or the runner of the -record and -replay flags.
+type Extraction struct {
+	CacheDir string
+	Workers  int
+	Verbose  bool
+	Progress func(done, total int)
+	Runner   CommandRunner
+	RunnerFlags
+}
+
+func (e *Extraction) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&e.CacheDir, "cache", DefaultCacheDir(),
+		"directory caching the commits extracted from git (empty disables it)")
+	fs.IntVar(&e.Workers, "P", runtime.NumCPU(), "number of parallel extraction workers")
+	fs.BoolVar(&e.Verbose, "v", false, "report extraction progress on stderr")
+	e.RunnerFlags.Register(fs)
+}
+
+func (e Extraction) progress() func(done, total int) {
+	if e.Progress == nil && e.Verbose {
+		return ProgressPrinter(os.Stderr, "commits")
+	}
+	return e.Progress
+}
+
+func (e Extraction) runner() CommandRunner {
+	if e.Runner == nil {
+		return e.RunnerFlags.Runner()
 	}
-	if err := cmd.Wait(); err != nil {
-		return nil, fmt.Errorf("wait %v", err)
+	return e.Runner
+}
+
+// correctedKind returns the kind of the issue id, corrected if it was.
+func correctedKind(corrected map[string]string, id, kind string) string {
+	if k, ok := corrected[id]; ok {
+		return k
 	}
-	return commits, nil
+	return kind
+}
+
+var (
+	ErrNoCommitsFile = errors.New("missing commits file (-c or <commits file>)")
+	ErrNoRepoPath    = errors.New("missing git repository path (-g or <git repo> <issues file>)")
+	ErrNoIssuesFile  = errors.New("missing issues file (-j or <git repo> <issues file>)")
+)
+
+// System describes a studied system: its commits, the layers of its files and
+// the issue keys of its commit messages, if its commits are linked by them.
+type System struct {
+	Source    func(Options, Config) CommitSource
+	Layers    LayerClassifier
+	IssueKeys func(string) []string
 }
 
-var ofbizRegex *regexp.Regexp
+var systems = map[string]System{
+	"siop": {
+		Source: siopSource,
+		Layers: LayerFunc(siopLayerExtractor)},
+	"ofbiz": {
+		Source:    gitSource(ofbizIssueExtractor),
+		Layers:    LayerFunc(ofbizLayerExtractor),
+		IssueKeys: ofbizIssueKeys},
+	"openmrs": {
+		Source:    gitSource(openmrsIssueExtractor),
+		Layers:    LayerFunc(openmrsLayerExtractor),
+		IssueKeys: openmrsIssueKeys},
+}
 
-func ofbizIssueExtractor(description string) string {
-	if ofbizRegex == nil {
-		var err error
-		ofbizRegex, err = regexp.Compile("OFBIZ-\\d+")
-		if err != nil {
-			log.Fatal(err)
+func LookupSystem(name string) (System, error) {
+	s, ok := systems[name]
+	if !ok {
+		return System{}, fmt.Errorf("unknown system %q (choose one of %v)", name,
+			strings.Join(SystemNames(), ", "))
+	}
+	return s, nil
+}
+
+func SystemNames() []string {
+	names := make([]string, 0, len(systems))
+	for k := range systems {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func siopSource(o Options, c Config) CommitSource {
+	return &JSONCommitSource{File: o.CommitsFile, Options: o, Policies: c.Policies, Zones: c.Zones,
+		Corrected: c.Corrected}
+}
+
+func gitSource(issueExtractor func(string) string) func(Options, Config) CommitSource {
+	return func(o Options, c Config) CommitSource {
+		s := &GitSource{
+			Dir:            o.RepoPath,
+			Issues:         CSVIssueSource(o.IssuesFile),
+			IssueExtractor: issueExtractor,
+			Options:        o,
+			Policies:       c.Policies,
+			Zones:          c.Zones,
+			Extraction:     c.Extraction,
+			Corrected:      c.Corrected}
+		if c.Extraction.CacheDir != "" {
+			s.Cache = &Cache{Dir: c.Extraction.CacheDir}
 		}
+		return s
 	}
+}
+
+var (
+	ofbizRegex   = regexp.MustCompile("OFBIZ-\\d+")
+	openmrsRegex = regexp.MustCompile("TRUNK-\\d+")
+)
+
+func ofbizIssueExtractor(description string) string {
 	return ofbizRegex.FindString(description)
 }
 
-var openmrsRegex *regexp.Regexp
+func ofbizIssueKeys(description string) []string {
+	return ofbizRegex.FindAllString(description, -1)
+}
 
 func openmrsIssueExtractor(description string) string {
-	if openmrsRegex == nil {
-		var err error
-		openmrsRegex, err = regexp.Compile("TRUNK-\\d+")
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
 	return openmrsRegex.FindString(description)
 }
 
+func openmrsIssueKeys(description string) []string {
+	return openmrsRegex.FindAllString(description, -1)
+}
+
 func siopLayerExtractor(file string) string {
 	layer := strings.Split(file, "/")[1]
 	switch layer {
@@ -0,0 +1,267 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitSource extracts the commits of a git repository, linking them to the
+// issues referenced in their messages. When Cache is set, extracted commits
+// are stored there and only the commits added since the last run are read
+// from the repository.
+type GitSource struct {
+	Dir            string
+	Issues         IssueSource
+	IssueExtractor func(string) string
+	Cache          *Cache
+	Options        Options
+	Policies       Policies
+	Zones          Zones
+	Extraction     Extraction
+	Corrected      map[string]string
+}
+
+func (s *GitSource) Commits() (CommitReader, error) {
+	if s.Dir == "" {
+		return nil, ErrNoRepoPath
+	}
+	if s.Issues == nil {
+		return nil, ErrNoIssuesFile
+	}
+	if err := s.Policies.check(); err != nil {
+		return nil, err
+	}
+	rule, err := ParseFeatureRule(s.Options.FeatureBy)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := s.policies()
+	if err != nil {
+		return nil, err
+	}
+	issues, err := s.Issues.Issues()
+	if err != nil {
+		return nil, err
+	}
+	link := func(c *Commit) bool {
+		id := s.IssueExtractor(c.Change.Comment)
+		kind := ""
+		if item := issues.Item(id); item != nil {
+			kind = item.Kind
+		}
+		if strings.EqualFold(correctedKind(s.Corrected, id, kind), "Bug") {
+			kind = "Bug"
+		} else {
+			kind = "Improvement"
+		}
+		c.Issue = Issue{Id: id, Kind: kind}
+		c.Epic = issues.RollUp(id, "Epic")
+		c.Feature = rule.Feature(issues, id)
+		return s.Options.keep(c) && policies(c)
+	}
+	var r CommitReader
+	if s.Cache != nil {
+		r, err = s.Cache.open(s, link)
+	} else {
+		r, err = s.log("HEAD", link)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &screenReader{r, s.Policies, s.Zones}, nil
+}
+
+// log reads the commits of the revision range rev, skipping before listing
+// their files the ones for which keep returns false.
+func (s *GitSource) log(rev string, keep func(*Commit) bool) (*gitCommitReader, error) {
+	stdout, err := s.Extraction.runner().Start(context.Background(), s.Dir, "git", "--no-pager",
+		"log", "--date=iso", "--reverse", "--pretty=format:%H%x09%an%x09%ad%x09%P%x09%cd%x09%cn%x09"+
+			"%(trailers:key=Co-authored-by,valueonly,separator=%x1f)%x09%s", rev)
+	if err != nil {
+		return nil, err
+	}
+	r := &gitCommitReader{source: s, keep: keep, stdout: stdout,
+		scan: bufio.NewScanner(stdout), progress: s.Extraction.progress()}
+	if r.progress != nil {
+		if r.total, err = s.count(rev); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (s *GitSource) count(rev string) (int, error) {
+	out, err := s.git("rev-list", "--count", rev)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(out)
+}
+
+func (s *GitSource) head() (string, error) {
+	return s.git("rev-parse", "HEAD")
+}
+
+func (s *GitSource) isAncestor(rev string) bool {
+	_, err := s.git("merge-base", "--is-ancestor", rev, "HEAD")
+	return err == nil
+}
+
+func (s *GitSource) git(args ...string) (string, error) {
+	out, err := s.Extraction.runner().Output(context.Background(), s.Dir, "git", args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitCommitReader reads the log in batches, listing the files of the
+// commits of a batch in parallel.
+type gitCommitReader struct {
+	source   *GitSource
+	keep     func(*Commit) bool
+	stdout   io.ReadCloser
+	scan     *bufio.Scanner
+	done     bool
+	batch    []*Commit
+	read     int
+	total    int
+	progress func(done, total int)
+}
+
+func (r *gitCommitReader) Read() (*Commit, error) {
+	if len(r.batch) == 0 {
+		if err := r.fill(); err != nil {
+			return nil, err
+		}
+		if len(r.batch) == 0 {
+			return nil, io.EOF
+		}
+	}
+	c := r.batch[0]
+	r.batch = r.batch[1:]
+	return c, nil
+}
+
+func (r *gitCommitReader) fill() error {
+	workers := r.source.Extraction.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	batch := make([]*Commit, 0, workers*16)
+	for len(batch) < cap(batch) && r.scan.Scan() {
+		r.read++
+		commit, err := r.parse(r.scan.Text())
+		if err != nil {
+			return err
+		}
+		if r.keep == nil || r.keep(commit) {
+			batch = append(batch, commit)
+		}
+	}
+	if err := r.scan.Err(); err != nil {
+		return err
+	}
+	pool := Pool{Workers: workers}
+	err := pool.Run(context.Background(), len(batch), func(ctx context.Context, i int) error {
+		var err error
+		batch[i].Files, err = r.source.files(ctx, batch[i].Change)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) == 0 {
+		return r.wait()
+	}
+	if r.progress != nil {
+		r.progress(r.read, r.total)
+	}
+	r.batch = batch
+	return nil
+}
+
+// gitTimeLayout is the layout of the author and committer dates of the log,
+// which keep the offsets they were recorded with.
+const gitTimeLayout = "2006-01-02 15:04:05 -0700"
+
+func (r *gitCommitReader) parse(line string) (*Commit, error) {
+	arr := strings.SplitN(line, "\t", 8)
+	if len(arr) < 8 {
+		return nil, fmt.Errorf("unexpected git log line: %q", line)
+	}
+	modified, err := time.Parse(gitTimeLayout, arr[2])
+	if err != nil {
+		return nil, err
+	}
+	committed, err := time.Parse(gitTimeLayout, arr[4])
+	if err != nil {
+		return nil, err
+	}
+	coAuthors := []string{}
+	for _, value := range strings.Split(arr[6], "\x1f") {
+		if name := trailerName(value); name != "" {
+			coAuthors = append(coAuthors, name)
+		}
+	}
+	return &Commit{
+		Change: &Change{
+			Uuid:          arr[0],
+			Author:        arr[1],
+			Comment:       arr[7],
+			Modified:      arr[2],
+			ModifiedTime:  modified,
+			Committed:     arr[4],
+			CommittedTime: committed,
+			Committer:     arr[5],
+			CoAuthors:     distinct(coAuthors),
+			Parents:       strings.Fields(arr[3]),
+		},
+	}, nil
+}
+
+// files lists the files changed by c. Merges are listed only when collapsing
+// their branches, with the files of the branch, which diff-tree would
+// otherwise list none or a few of; under the other policies they are left
+// unlisted (nil) as they count no files.
+func (s *GitSource) files(ctx context.Context, c *Change) ([]string, error) {
+	args := []string{"diff-tree", "--no-commit-id", "--name-only", "-r"}
+	if len(c.Parents) > 1 {
+		if s.Policies.Merges != CollapseMerges {
+			return nil, nil
+		}
+		args = append(args, c.Parents[0])
+	}
+	outTree, err := s.Extraction.runner().Output(ctx, s.Dir, "git", append(args, c.Uuid)...)
+	if err != nil {
+		return nil, err
+	}
+	files := strings.Split(string(outTree), "\n")
+	if len(files) > 0 && files[len(files)-1] == "" {
+		files = files[:len(files)-1]
+	}
+	return files, nil
+}
+
+func (r *gitCommitReader) wait() error {
+	if r.done {
+		return nil
+	}
+	r.done = true
+	return r.stdout.Close()
+}
+
+func (r *gitCommitReader) Close() error {
+	if r.done {
+		return nil
+	}
+	r.done = true
+	r.stdout.Close()
+	return nil
+}
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The recordings of testdata/replay/git were made with -record by stats on
+// a sample OFBiz history of five commits, the first of which, the root one,
+// lists no files.
+func TestGitSourceReplay(t *testing.T) {
+	system, err := LookupSystem("ofbiz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{RepoPath: filepath.Join("testdata", "replay", "ofbiz"),
+		IssuesFile: filepath.Join("testdata", "replay", "issues.csv"), FeatureBy: "epic,component"}
+	config := Config{Extraction: Extraction{Workers: 2,
+		Runner: &Replayer{Dir: filepath.Join("testdata", "replay", "git")}}}
+	r, err := system.Source(opts, config).Commits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, err := ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type summary struct {
+		Comment, Issue, Kind, Author, Committer string
+		CoAuthors, Files                        []string
+	}
+	want := []summary{
+		{"initial import", "", "Improvement", "Alice", "Alice", []string{}, []string{}},
+		{"OFBIZ-1 add invoice entity", "OFBIZ-1", "Bug", "Alice", "Alice", []string{},
+			[]string{"applications/accounting/entitydef/entitymodel.xml",
+				"applications/accounting/src/InvoiceServices.java"}},
+		{"OFBIZ-2 fix order screen", "OFBIZ-2", "Improvement", "Alice", "Alice", []string{},
+			[]string{"applications/order/webapp/order/order.ftl",
+				"applications/order/widget/OrderScreens.xml"}},
+		{"OFBIZ-1 invoice report", "OFBIZ-1", "Bug", "Alice", "Bob", []string{"Carol"},
+			[]string{"applications/accounting/webapp/accounting/invoice.ftl"}},
+		{"cleanup build", "", "Improvement", "Alice", "Alice", []string{},
+			[]string{"framework/base/build.xml"}},
+	}
+	got := []summary{}
+	for _, c := range commits {
+		got = append(got, summary{c.Change.Comment, c.Issue.Id, c.Issue.Kind, c.Change.Author,
+			c.Change.Committer, c.Change.CoAuthors, c.Files})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commits =\n%+v\nwant\n%+v", got, want)
+	}
+	if offset := commits[1].Change.ModifiedTime.Format("-0700"); offset != "-0200" {
+		t.Errorf("author time offset = %v, want the recorded -0200", offset)
+	}
+}
+
+func TestSetArgs(t *testing.T) {
+	dir := t.TempDir()
+	var o Options
+	o.SetArgs([]string{dir})
+	if o.RepoPath != dir || o.CommitsFile != "" {
+		t.Errorf("SetArgs(%v) set the repository %q and the commits file %q", dir, o.RepoPath, o.CommitsFile)
+	}
+	o = Options{}
+	o.SetArgs([]string{"commits.json"})
+	if o.CommitsFile != "commits.json" || o.RepoPath != "" {
+		t.Errorf("SetArgs(commits.json) set the repository %q and the commits file %q", o.RepoPath, o.CommitsFile)
+	}
+	o = Options{}
+	o.SetArgs([]string{dir, "issues.csv"})
+	if o.RepoPath != dir || o.IssuesFile != "issues.csv" {
+		t.Errorf("SetArgs(%v, issues.csv) set the repository %q and the issues file %q", dir, o.RepoPath, o.IssuesFile)
+	}
+}
+
+func TestSourceFlagsConfig(t *testing.T) {
+	f := SourceFlags{Zone: "America/Sao_Paulo", Normalize: "UTC", Policies: Policies{Merges: CollapseMerges}}
+	config, err := f.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Zones.Recorded.String() != "America/Sao_Paulo" || config.Zones.Reporting != time.UTC ||
+		config.Policies.Merges != CollapseMerges || config.Corrected != nil {
+		t.Errorf("config = %+v", config)
+	}
+	for _, f := range []SourceFlags{{Zone: "Mars/Olympus"}, {Normalize: "Mars/Olympus"},
+		{Policies: Policies{Merges: "squash"}}, {Corrections: filepath.Join(t.TempDir(), "missing.csv")}} {
+		if _, err := f.Config(); err == nil {
+			t.Errorf("Config of %+v succeeded", f)
+		}
+	}
+}
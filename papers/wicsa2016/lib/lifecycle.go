@@ -0,0 +1,231 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChangelogSchema describes the changelog file written by the fetcher: one
+// event per record, where Field is created, resolved (the resolution date
+// of Jira), status or resolution.
+var ChangelogSchema = Schema{Name: "changelog", Columns: []Column{
+	{Name: "Key", Required: true},
+	{Name: "Time", Required: true},
+	{Name: "Field", Required: true},
+	{Name: "From"},
+	{Name: "To"}}}
+
+// Transition is a change of a field of an issue.
+type Transition struct {
+	Time  time.Time
+	Field string
+	From  string
+	To    string
+}
+
+// IssueHistory is the changelog of an issue, transitions in time order.
+type IssueHistory struct {
+	Key         string
+	Created     time.Time
+	Resolved    time.Time
+	Transitions []Transition
+}
+
+// Records returns the changelog file records of the history.
+func (h *IssueHistory) Records() [][]string {
+	records := [][]string{{h.Key, h.Created.Format(time.RFC3339), "created", "", ""}}
+	for _, t := range h.Transitions {
+		records = append(records, []string{h.Key, t.Time.Format(time.RFC3339), t.Field, t.From, t.To})
+	}
+	if !h.Resolved.IsZero() {
+		records = append(records, []string{h.Key, h.Resolved.Format(time.RFC3339), "resolved", "", ""})
+	}
+	return records
+}
+
+func ChangelogHeader() []string {
+	header := make([]string, len(ChangelogSchema.Columns))
+	for i, c := range ChangelogSchema.Columns {
+		header[i] = c.Name
+	}
+	return header
+}
+
+// LoadChangelog reads the changelog file written by the fetcher.
+func LoadChangelog(file string) (map[string]*IssueHistory, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening changelog file: %v", err)
+	}
+	defer f.Close()
+	t, err := NewTableReader(f, ChangelogSchema)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	histories := map[string]*IssueHistory{}
+	for {
+		row, err := t.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+		at, err := time.Parse(time.RFC3339, row.Get("Time"))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", file, row.Error("bad time %q", row.Get("Time")))
+		}
+		key := row.Get("Key")
+		h := histories[key]
+		if h == nil {
+			h = &IssueHistory{Key: key}
+			histories[key] = h
+		}
+		switch field := row.Get("Field"); field {
+		case "created":
+			h.Created = at
+		case "resolved":
+			h.Resolved = at
+		default:
+			h.Transitions = append(h.Transitions,
+				Transition{Time: at, Field: field, From: row.Get("From"), To: row.Get("To")})
+		}
+	}
+	for _, h := range histories {
+		sort.SliceStable(h.Transitions, func(i, j int) bool {
+			return h.Transitions[i].Time.Before(h.Transitions[j].Time)
+		})
+	}
+	return histories, nil
+}
+
+// Lifecycle tells which statuses start the work on an issue and which end
+// it, compared ignoring case.
+type Lifecycle struct {
+	Started []string
+	Done    []string
+}
+
+var DefaultLifecycle = Lifecycle{
+	Started: []string{"In Progress", "Patch Available", "In Review"},
+	Done:    []string{"Resolved", "Closed", "Done"}}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// IssueTimes are the lifecycle measures of an issue. The lead time is known
+// only if Resolved, the cycle time if Started too and the time to the first
+// commit if Committed.
+type IssueTimes struct {
+	Key               string
+	Layers            int
+	LeadTime          time.Duration
+	CycleTime         time.Duration
+	TimeToFirstCommit time.Duration
+	Reopens           int
+	Resolved          bool
+	Started           bool
+	Committed         bool
+}
+
+// Times measures the history: the lead time from creation to resolution,
+// the cycle time from the first started status to resolution, the time from
+// creation to firstCommit (zero if unknown) and the number of times the
+// issue left a done status.
+func (l Lifecycle) Times(h *IssueHistory, firstCommit time.Time) IssueTimes {
+	t := IssueTimes{Key: h.Key}
+	var started time.Time
+	for _, tr := range h.Transitions {
+		if tr.Field != "status" {
+			continue
+		}
+		if started.IsZero() && containsFold(l.Started, tr.To) {
+			started = tr.Time
+		}
+		if containsFold(l.Done, tr.From) && !containsFold(l.Done, tr.To) {
+			t.Reopens++
+		}
+	}
+	if !h.Resolved.IsZero() && !h.Created.IsZero() {
+		t.Resolved = true
+		t.LeadTime = h.Resolved.Sub(h.Created)
+		if !started.IsZero() && !started.After(h.Resolved) {
+			t.Started = true
+			t.CycleTime = h.Resolved.Sub(started)
+		}
+	}
+	if !firstCommit.IsZero() && !h.Created.IsZero() {
+		t.Committed = true
+		t.TimeToFirstCommit = firstCommit.Sub(h.Created)
+	}
+	return t
+}
+
+// Median returns the median of values, NaN if there are none.
+func Median(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	v := append([]float64{}, values...)
+	sort.Float64s(v)
+	if len(v)%2 == 1 {
+		return v[len(v)/2]
+	}
+	return (v[len(v)/2-1] + v[len(v)/2]) / 2
+}
+
+// Spearman returns the rank correlation of x and y, NaN if it is undefined.
+func Spearman(x, y []float64) float64 {
+	return pearson(ranks(x), ranks(y))
+}
+
+// ranks returns the ranks of values, ties getting the mean of their ranks.
+func ranks(values []float64) []float64 {
+	idx := make([]int, len(values))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return values[idx[i]] < values[idx[j]] })
+	r := make([]float64, len(values))
+	for i := 0; i < len(idx); {
+		j := i
+		for j < len(idx) && values[idx[j]] == values[idx[i]] {
+			j++
+		}
+		for k := i; k < j; k++ {
+			r[idx[k]] = float64(i+j+1) / 2
+		}
+		i = j
+	}
+	return r
+}
+
+func pearson(x, y []float64) float64 {
+	n := float64(len(x))
+	if len(x) < 2 || len(x) != len(y) {
+		return math.NaN()
+	}
+	var sx, sy float64
+	for i := range x {
+		sx += x[i]
+		sy += y[i]
+	}
+	mx, my := sx/n, sy/n
+	var cov, vx, vy float64
+	for i := range x {
+		cov += (x[i] - mx) * (y[i] - my)
+		vx += (x[i] - mx) * (x[i] - mx)
+		vy += (y[i] - my) * (y[i] - my)
+	}
+	return cov / math.Sqrt(vx*vy)
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package lib
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile creates file as the lock, failing if another run holds it. Without
+// advisory locks, the file of an interrupted run has to be removed by hand.
+func lockFile(file string) (unlock func() error, err error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%v is locked by another run (remove it if none is running)", file)
+	} else if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return func() error { return os.Remove(file) }, nil
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile waits for the exclusive lock of file, created if missing. The lock
+// is released by unlock or when the process exits, so that an interrupted
+// run leaves no stale lock behind.
+func lockFile(file string) (unlock func() error, err error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error locking %v: %v", file, err)
+	}
+	return f.Close, nil
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// The categories of ClassifyCommit: the maintenance activities of Swanson,
+// plus the commits that change no behavior.
+const (
+	Corrective  = "corrective"
+	Adaptive    = "adaptive"
+	Perfective  = "perfective"
+	Refactoring = "refactoring"
+	Merge       = "merge"
+	Formatting  = "formatting"
+	Unknown     = "unknown"
+)
+
+// keywords are the stems of the words telling each category, in the order
+// they are tried: "fix typo" is formatting, "fix NPE" corrective.
+var keywords = []struct {
+	category string
+	stems    []string
+}{
+	{Formatting, []string{"format", "reformat", "whitespace", "indent", "typo", "spacing",
+		"trailing", "checkstyle", "cosmetic", "tabs", "style", "copyright", "license"}},
+	{Refactoring, []string{"refactor", "rename", "restructur", "reorganiz", "cleanup",
+		"clean", "extract", "move", "simplif", "tidy", "duplicat"}},
+	{Corrective, []string{"fix", "bug", "error", "fail", "crash", "npe", "exception", "wrong",
+		"problem", "defect", "broken", "incorrect", "repair", "resolv", "issue"}},
+	{Adaptive, []string{"add", "new", "feature", "support", "implement", "allow", "introduc",
+		"migrat", "upgrad", "integrat", "enabl"}},
+	{Perfective, []string{"improv", "enhanc", "optimi", "perform", "speed", "updat", "chang",
+		"doc", "javadoc", "comment", "test", "remov", "readme"}},
+}
+
+var mergeRegex = regexp.MustCompile(`^\s*(merge|merged|merging)\b`)
+
+// bulkFiles is the size from which commits matching no keyword are taken as
+// formatting, as they are mostly reformatting or license headers.
+const bulkFiles = 50
+
+// ClassifyCommit labels a commit with the category of its message and, when
+// the message tells none, of the files it changed.
+func ClassifyCommit(c *Commit) string {
+	message := strings.ToLower(c.Change.Comment)
+	if mergeRegex.MatchString(message) {
+		return Merge
+	}
+	words := strings.FieldsFunc(message, func(r rune) bool { return !unicode.IsLetter(r) })
+	for _, k := range keywords {
+		for _, w := range words {
+			for _, stem := range k.stems {
+				if strings.HasPrefix(w, stem) {
+					return k.category
+				}
+			}
+		}
+	}
+	if len(c.Files) >= bulkFiles {
+		return Formatting
+	}
+	if len(c.Files) > 0 && allFiles(c.Files, isTestOrDoc) {
+		return Perfective
+	}
+	return Unknown
+}
+
+func allFiles(files []string, f func(string) bool) bool {
+	for _, file := range files {
+		if !f(file) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTestOrDoc(file string) bool {
+	switch strings.ToLower(path.Ext(file)) {
+	case ".txt", ".md", ".apt", ".rst":
+		return true
+	}
+	return strings.Contains(file, "/test/") || strings.HasSuffix(path.Base(file), "Test.java")
+}
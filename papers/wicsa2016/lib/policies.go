@@ -0,0 +1,219 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// The flags of Commit.Flags.
+const (
+	MergeFlag    = "merge"
+	RevertFlag   = "revert"
+	RevertedFlag = "reverted"
+	OutlierFlag  = "outlier"
+)
+
+// Merge policies: keep merges as commits changing no files, as git diff-tree
+// lists them, drop them, or collapse each branch into its merge, which then
+// changes the files of the branch, by reading only the first parent history.
+const (
+	KeepMerges     = "keep"
+	DropMerges     = "drop"
+	CollapseMerges = "collapse"
+)
+
+// Revert and outlier policies: keep (reverts only), flag or drop. Dropping
+// reverts drops the commits they revert too.
+const (
+	KeepCommits = "keep"
+	FlagCommits = "flag"
+	DropCommits = "drop"
+)
+
+// Policies are the policies on merges, reverts and outliers, the commits
+// changing more than MaxFiles files (0 allows any number).
+type Policies struct {
+	Merges   string
+	Reverts  string
+	MaxFiles int
+	Outliers string
+}
+
+func (p *Policies) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.Merges, "merges", KeepMerges, "merge commits: keep (changing no files), drop "+
+		"or collapse (into the first parent history, each merge changing the files of its branch)")
+	fs.StringVar(&p.Reverts, "reverts", KeepCommits, "reverts and the commits they revert: keep, flag or drop")
+	fs.IntVar(&p.MaxFiles, "max-files", 0, "commits changing more files are outliers (0 disables it)")
+	fs.StringVar(&p.Outliers, "outliers", FlagCommits, "outlier commits: flag or drop")
+}
+
+func (p Policies) check() error {
+	if p.Merges != "" && p.Merges != KeepMerges && p.Merges != DropMerges && p.Merges != CollapseMerges {
+		return fmt.Errorf("unknown merge policy %q (choose among keep, drop, collapse)", p.Merges)
+	}
+	if p.Reverts != "" && p.Reverts != KeepCommits && p.Reverts != FlagCommits && p.Reverts != DropCommits {
+		return fmt.Errorf("unknown revert policy %q (choose among keep, flag, drop)", p.Reverts)
+	}
+	if p.Outliers != "" && p.Outliers != FlagCommits && p.Outliers != DropCommits {
+		return fmt.Errorf("unknown outlier policy %q (choose among flag, drop)", p.Outliers)
+	}
+	return nil
+}
+
+// screen flags the commits changing more than MaxFiles files, reporting
+// whether they are kept. Merges keep the files of their branch, which are
+// counted on the commits of the branch, only when collapsing it; the ones
+// cached by a collapsing run are cleared.
+func (p Policies) screen(c *Commit) bool {
+	if c.Files == nil || c.isMerge() && p.Merges != CollapseMerges {
+		c.Files = []string{}
+	}
+	if p.MaxFiles == 0 || len(c.Files) <= p.MaxFiles {
+		return true
+	}
+	if p.Outliers == DropCommits {
+		return false
+	}
+	c.Flags = append(c.Flags, OutlierFlag)
+	return true
+}
+
+// screenReader applies the policies needing the files of the commits.
+type screenReader struct {
+	CommitReader
+	policies Policies
+	zones    Zones
+}
+
+func (r *screenReader) Read() (*Commit, error) {
+	for {
+		c, err := r.CommitReader.Read()
+		if err != nil {
+			return nil, err
+		}
+		if r.policies.screen(c) {
+			r.zones.normalize(c)
+			return c, nil
+		}
+	}
+}
+
+func (c *Commit) isMerge() bool {
+	return len(c.Change.Parents) > 1
+}
+
+var (
+	revertBodyRegex    = regexp.MustCompile(`This reverts commit ([0-9a-f]{7,40})`)
+	revertSubjectRegex = regexp.MustCompile(`^Revert "(.+)"$`)
+)
+
+// reverts maps the reverts of the history of s to the commits they revert,
+// telling them by the body git revert writes or, failing that, by a subject
+// reverting the one of an earlier commit.
+func (s *GitSource) reverts() (map[string]string, error) {
+	stdout, err := s.Extraction.runner().Start(context.Background(), s.Dir, "git", "--no-pager",
+		"log", "--reverse", "--pretty=format:%H%x1f%s%x1f%b%x1e", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	reverts := map[string]string{}
+	reverted := map[string]bool{}
+	hashes := []string{}
+	bySubject := map[string]string{}
+	in := bufio.NewReader(stdout)
+	for {
+		record, err := in.ReadString('\x1e')
+		if err == io.EOF && record == "" {
+			break
+		} else if err != nil && err != io.EOF {
+			stdout.Close()
+			return nil, err
+		}
+		arr := strings.SplitN(strings.TrimLeft(strings.TrimSuffix(record, "\x1e"), "\n"), "\x1f", 3)
+		if len(arr) < 3 {
+			continue
+		}
+		hash, subject, body := arr[0], arr[1], arr[2]
+		original := ""
+		if m := revertBodyRegex.FindStringSubmatch(body); m != nil {
+			for i := len(hashes) - 1; i >= 0; i-- {
+				if strings.HasPrefix(hashes[i], m[1]) {
+					original = hashes[i]
+					break
+				}
+			}
+		} else if m := revertSubjectRegex.FindStringSubmatch(subject); m != nil {
+			original = bySubject[m[1]]
+		}
+		if original != "" && !reverted[original] {
+			reverts[hash] = original
+			reverted[original] = true
+		}
+		hashes = append(hashes, hash)
+		bySubject[subject] = hash
+	}
+	return reverts, stdout.Close()
+}
+
+// firstParents returns the commits of the first parent history of HEAD.
+func (s *GitSource) firstParents() (map[string]bool, error) {
+	out, err := s.git("rev-list", "--first-parent", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	commits := map[string]bool{}
+	for _, h := range strings.Fields(out) {
+		commits[h] = true
+	}
+	return commits, nil
+}
+
+// policies returns the function applying the merge and revert policies to
+// the commits of s, before their files are listed.
+func (s *GitSource) policies() (func(*Commit) bool, error) {
+	o := s.Policies
+	var firstParents map[string]bool
+	var reverts map[string]string
+	reverted := map[string]bool{}
+	var err error
+	if o.Merges == CollapseMerges {
+		if firstParents, err = s.firstParents(); err != nil {
+			return nil, err
+		}
+	}
+	if o.Reverts == FlagCommits || o.Reverts == DropCommits {
+		if reverts, err = s.reverts(); err != nil {
+			return nil, err
+		}
+		for _, original := range reverts {
+			reverted[original] = true
+		}
+	}
+	return func(c *Commit) bool {
+		if c.isMerge() {
+			if o.Merges == DropMerges {
+				return false
+			}
+			c.Flags = append(c.Flags, MergeFlag)
+		}
+		if firstParents != nil && !firstParents[c.Change.Uuid] {
+			return false
+		}
+		_, revert := reverts[c.Change.Uuid]
+		if (revert || reverted[c.Change.Uuid]) && o.Reverts == DropCommits {
+			return false
+		}
+		if revert {
+			c.Flags = append(c.Flags, RevertFlag)
+		}
+		if reverted[c.Change.Uuid] {
+			c.Flags = append(c.Flags, RevertedFlag)
+		}
+		return true
+	}, nil
+}
@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The recordings of testdata/policies/git were made with -record by stats
+// on a sample OFBiz history with a merged branch, a revert written by git
+// revert, one telling the commit it reverts only by its subject and a commit
+// changing six files.
+
+// loggingRunner replays the recordings of testdata/policies/git, logging the
+// commands run.
+type loggingRunner struct {
+	Replayer
+	mu       sync.Mutex
+	commands []string
+}
+
+func (r *loggingRunner) Output(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	r.mu.Lock()
+	r.commands = append(r.commands, strings.Join(append([]string{name}, args...), " "))
+	r.mu.Unlock()
+	return r.Replayer.Output(ctx, dir, name, args...)
+}
+
+func (r *loggingRunner) Start(ctx context.Context, dir, name string, args ...string) (io.ReadCloser, error) {
+	return startFromOutput(r.Output(ctx, dir, name, args...))
+}
+
+func newPoliciesRunner() *loggingRunner {
+	return &loggingRunner{Replayer: Replayer{Dir: filepath.Join("testdata", "policies", "git")}}
+}
+
+var policiesOptions = Options{RepoPath: filepath.Join("testdata", "policies", "ofbiz"),
+	IssuesFile: filepath.Join("testdata", "policies", "issues.csv"), FeatureBy: "epic,component"}
+
+func policiesConfig(runner CommandRunner, merges, reverts string, maxFiles int) Config {
+	return Config{Policies: Policies{Merges: merges, Reverts: reverts, MaxFiles: maxFiles, Outliers: FlagCommits},
+		Extraction: Extraction{Workers: 2, Runner: runner}}
+}
+
+// summarize describes each commit as "<subject> <flags> <files>".
+func summarize(t *testing.T, config Config) []string {
+	system, err := LookupSystem("ofbiz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := system.Source(policiesOptions, config).Commits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, err := ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{}
+	for _, c := range commits {
+		lines = append(lines, fmt.Sprintf("%v %v %v", c.Change.Comment, c.Flags, len(c.Files)))
+	}
+	return lines
+}
+
+func TestMergePolicies(t *testing.T) {
+	common := []string{"initial import [] 0", "OFBIZ-1 add invoice entity [] 2"}
+	tail := []string{`Revert "OFBIZ-3 wrong build fix" [] 1`, "OFBIZ-1 tweak invoice [] 1",
+		`Revert "OFBIZ-1 tweak invoice" [] 1`, "OFBIZ-4 reformat sources [] 6"}
+	branch := []string{"OFBIZ-2 order screen [] 1", "OFBIZ-2 order service [] 1",
+		"OFBIZ-3 wrong build fix [] 1"}
+	tests := []struct {
+		merges string
+		want   []string
+	}{
+		{KeepMerges, append(append(append(common, branch...), "Merge branch 'order' [merge] 0"), tail...)},
+		{DropMerges, append(append(common, branch...), tail...)},
+		{CollapseMerges, append(append(common, "OFBIZ-3 wrong build fix [] 1",
+			"Merge branch 'order' [merge] 2"), tail...)},
+	}
+	for _, test := range tests {
+		runner := newPoliciesRunner()
+		got := summarize(t, policiesConfig(runner, test.merges, KeepCommits, 0))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v merges:\n%q\nwant\n%q", test.merges, got, test.want)
+		}
+		// only collapsing lists the files of the merge, against its first parent
+		listed := false
+		for _, cmd := range runner.commands {
+			listed = listed || strings.HasPrefix(cmd, "git diff-tree") && strings.Contains(cmd, " fc2445b7")
+		}
+		if listed != (test.merges == CollapseMerges) {
+			t.Errorf("%v merges: files of the merge listed: %v", test.merges, listed)
+		}
+	}
+}
+
+func TestRevertPolicies(t *testing.T) {
+	tests := []struct {
+		reverts string
+		want    []string
+	}{
+		{FlagCommits, []string{"initial import [] 0", "OFBIZ-1 add invoice entity [] 2",
+			"OFBIZ-2 order screen [] 1", "OFBIZ-2 order service [] 1",
+			"OFBIZ-3 wrong build fix [reverted] 1", "Merge branch 'order' [merge] 0",
+			`Revert "OFBIZ-3 wrong build fix" [revert] 1`, "OFBIZ-1 tweak invoice [reverted] 1",
+			`Revert "OFBIZ-1 tweak invoice" [revert] 1`, "OFBIZ-4 reformat sources [] 6"}},
+		{DropCommits, []string{"initial import [] 0", "OFBIZ-1 add invoice entity [] 2",
+			"OFBIZ-2 order screen [] 1", "OFBIZ-2 order service [] 1",
+			"Merge branch 'order' [merge] 0", "OFBIZ-4 reformat sources [] 6"}},
+	}
+	for _, test := range tests {
+		got := summarize(t, policiesConfig(newPoliciesRunner(), KeepMerges, test.reverts, 0))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v reverts:\n%q\nwant\n%q", test.reverts, got, test.want)
+		}
+	}
+}
+
+func TestRevertPairs(t *testing.T) {
+	s := &GitSource{Dir: filepath.Join("testdata", "policies", "ofbiz"),
+		Extraction: Extraction{Runner: newPoliciesRunner()}}
+	reverts, err := s.reverts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		// told by the body written by git revert
+		"6dfa3fcd24bb4ce10e1660065a96d7f5f76f818d": "01ceae0b389230fdcfed15aa76f760a75c4c9a94",
+		// told by the subject only
+		"a46c483cb80f2072b821a266ee2c1d1ff7eef360": "4e5f22f6cc4f4027e682145e4ee09726f6b471f1"}
+	if !reflect.DeepEqual(reverts, want) {
+		t.Errorf("reverts = %v, want %v", reverts, want)
+	}
+}
+
+func TestOutlierPolicy(t *testing.T) {
+	tests := []struct {
+		maxFiles int
+		outliers string
+		want     string
+	}{
+		{0, FlagCommits, "OFBIZ-4 reformat sources [] 6"},
+		{6, FlagCommits, "OFBIZ-4 reformat sources [] 6"},
+		{5, FlagCommits, "OFBIZ-4 reformat sources [outlier] 6"},
+		{5, DropCommits, `Revert "OFBIZ-1 tweak invoice" [] 1`},
+	}
+	for _, test := range tests {
+		config := policiesConfig(newPoliciesRunner(), KeepMerges, KeepCommits, test.maxFiles)
+		config.Policies.Outliers = test.outliers
+		got := summarize(t, config)
+		if last := got[len(got)-1]; last != test.want {
+			t.Errorf("-max-files %v -outliers %v: last commit %q, want %q", test.maxFiles,
+				test.outliers, last, test.want)
+		}
+	}
+}
+
+// A cache written without collapsing merges leaves them unlisted, for a
+// collapsing run to list.
+func TestCachedMergesListedWhenCollapsing(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		merges string
+		want   string
+	}{{KeepMerges, "Merge branch 'order' [merge] 0"}, {CollapseMerges, "Merge branch 'order' [merge] 2"},
+		{KeepMerges, "Merge branch 'order' [merge] 0"}} {
+		config := policiesConfig(newPoliciesRunner(), test.merges, KeepCommits, 0)
+		config.Extraction.CacheDir = dir
+		found := false
+		for _, line := range summarize(t, config) {
+			if strings.HasPrefix(line, "Merge") {
+				found = true
+				if line != test.want {
+					t.Errorf("%v merges: %q, want %q", test.merges, line, test.want)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%v merges: merge missing", test.merges)
+		}
+	}
+}
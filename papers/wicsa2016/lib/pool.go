@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Pool runs jobs on at most Workers goroutines. Progress, when set, is called
+// after each job completes with the number of jobs done so far.
+type Pool struct {
+	Workers  int
+	Progress func(done, total int)
+}
+
+// Run calls job for each i in [0, n). Results are expected to be stored by
+// index, which keeps them in order regardless of completion order. The first
+// error cancels the context given to the remaining jobs and is returned.
+func (p Pool) Run(ctx context.Context, n int, job func(ctx context.Context, i int) error) error {
+	workers := p.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		done     int
+	)
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				err := job(ctx, i)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				done++
+				if p.Progress != nil {
+					p.Progress(done, n)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+loop:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// ProgressPrinter reports progress on a single, rewritten line of w.
+func ProgressPrinter(w io.Writer, label string) func(done, total int) {
+	return func(done, total int) {
+		fmt.Fprintf(w, "\r%v: %v/%v", label, done, total)
+		if done == total {
+			fmt.Fprintln(w)
+		}
+	}
+}
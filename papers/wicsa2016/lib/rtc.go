@@ -0,0 +1,162 @@
+package lib
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"time"
+)
+
+// RTCClient lists the change sets of a Rational Team Concert (Jazz/EWM)
+// repository through its reportable REST API, as an alternative to the lscm
+// command line client.
+type RTCClient struct {
+	BaseURL  string
+	User     string
+	Password string
+	PageSize int
+	HTTP     *http.Client
+}
+
+// changeSetFields selects, from the scm resource, the change sets modified
+// in [%v, %v) with the attributes lscm reports.
+const changeSetFields = "scm/changeSet[modified>=%v and modified<%v]/" +
+	"(itemId|comment|modified|author/name|changes/(afterState/path|beforeState/path))"
+
+type rtcScm struct {
+	Href       string         `xml:"href,attr"`
+	Rel        string         `xml:"rel,attr"`
+	ChangeSets []rtcChangeSet `xml:"changeSet"`
+}
+
+type rtcChangeSet struct {
+	ItemId   string      `xml:"itemId"`
+	Comment  string      `xml:"comment"`
+	Modified string      `xml:"modified"`
+	Author   string      `xml:"author>name"`
+	Changes  []rtcChange `xml:"changes"`
+}
+
+// rtcChange is a change of a change set; deleted files have no after state.
+type rtcChange struct {
+	Path   string `xml:"afterState>path"`
+	Before string `xml:"beforeState>path"`
+}
+
+func (c rtcChange) path() string {
+	if c.Path == "" {
+		return c.Before
+	}
+	return c.Path
+}
+
+func (c *RTCClient) client() *http.Client {
+	if c.HTTP == nil {
+		jar, _ := cookiejar.New(nil)
+		c.HTTP = &http.Client{Jar: jar, Timeout: 5 * time.Minute}
+	}
+	return c.HTTP
+}
+
+// Login authenticates with the form based login of the Jazz server, keeping
+// the session cookie for the following requests.
+func (c *RTCClient) Login() error {
+	if c.User == "" {
+		return nil
+	}
+	resp, err := c.client().PostForm(c.BaseURL+"/j_security_check",
+		url.Values{"j_username": {c.User}, "j_password": {c.Password}})
+	if err != nil {
+		return fmt.Errorf("rtc: login: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK ||
+		resp.Header.Get("X-com-ibm-team-repository-web-auth-msg") == "authfailed" {
+		return errors.New("rtc: login failed for " + c.User)
+	}
+	return nil
+}
+
+// Changesets returns the change sets modified in [from, to), including the
+// paths of their changes, in the format of "lscm list changesets -j" but with
+// RFC 3339 timestamps.
+func (c *RTCClient) Changesets(from, to time.Time) (*Changeset, error) {
+	size := c.PageSize
+	if size <= 0 {
+		size = 100
+	}
+	fields := fmt.Sprintf(changeSetFields, from.Format(rtcTimeLayout), to.Format(rtcTimeLayout))
+	next := fmt.Sprintf("%v/rpt/repository/scm?size=%v&fields=%v", c.BaseURL, size,
+		url.QueryEscape(fields))
+	result := &Changeset{Changes: []Change{}}
+	for next != "" {
+		scm, err := c.get(next)
+		if err != nil {
+			return nil, err
+		}
+		for _, cs := range scm.ChangeSets {
+			change, err := cs.change()
+			if err != nil {
+				return nil, err
+			}
+			result.Changes = append(result.Changes, change)
+		}
+		next = ""
+		if scm.Rel == "next" {
+			next = scm.Href
+		}
+	}
+	return result, nil
+}
+
+func (c *RTCClient) get(u string) (*rtcScm, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/xml")
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("rtc: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("rtc: GET %v: %v %s", u, resp.Status, b)
+	}
+	scm := &rtcScm{}
+	if err := xml.NewDecoder(resp.Body).Decode(scm); err != nil {
+		return nil, fmt.Errorf("rtc: parsing %v: %v", u, err)
+	}
+	return scm, nil
+}
+
+const rtcTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+func (cs rtcChangeSet) change() (Change, error) {
+	modified, err := time.Parse(rtcTimeLayout, cs.Modified)
+	if err != nil {
+		return Change{}, fmt.Errorf("rtc: change set %v: %v", cs.ItemId, err)
+	}
+	change := Change{
+		Author:       cs.Author,
+		Comment:      cs.Comment,
+		Modified:     modified.Format(time.RFC3339),
+		ModifiedTime: modified,
+		Uuid:         cs.ItemId,
+		Changes:      make([]File, 0, len(cs.Changes))}
+	for _, c := range cs.Changes {
+		if p := c.path(); p != "" {
+			change.Changes = append(change.Changes, File{Path: p})
+		} else {
+			fmt.Fprintf(os.Stderr, "rtc: change set %v: skipping a change without path\n", cs.ItemId)
+		}
+	}
+	return change, nil
+}
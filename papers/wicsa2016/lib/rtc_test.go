@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rtcStub replays the recorded pages of testdata/rtc, scm-<page>.xml, with
+// {{base}} standing for the URL of the stub.
+func rtcStub(t *testing.T) (*httptest.Server, *[]string) {
+	requests := &[]string{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.URL.RequestURI())
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			page = "1"
+		}
+		b, err := os.ReadFile(filepath.Join("testdata", "rtc", "scm-"+page+".xml"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(strings.Replace(string(b), "{{base}}", server.URL, -1)))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestRTCClientChangesets(t *testing.T) {
+	server, requests := rtcStub(t)
+	client := &RTCClient{BaseURL: server.URL, PageSize: 1}
+	from := time.Date(2012, 2, 1, 0, 0, 0, 0, time.UTC)
+	cs, err := client.Changesets(from, from.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 2 {
+		t.Fatalf("requests = %v, want the two pages", *requests)
+	}
+	if !strings.Contains((*requests)[0], "size=1") {
+		t.Errorf("first request %v does not ask for pages of 1", (*requests)[0])
+	}
+	if len(cs.Changes) != 2 {
+		t.Fatalf("got %v change sets, want 2", len(cs.Changes))
+	}
+	first, second := cs.Changes[0], cs.Changes[1]
+	if first.Uuid != "_kT0aQGLsEeKxk5yUo7xWSA" || first.Author != "Ana Souza" ||
+		first.Comment != "20: Relatórios de execução" {
+		t.Errorf("first change set = %+v", first)
+	}
+	want := time.Date(2012, 2, 3, 16, 5, 22, 310000000, time.UTC)
+	if !first.ModifiedTime.Equal(want) {
+		t.Errorf("first change set modified at %v, want %v", first.ModifiedTime, want)
+	}
+	if len(first.Changes) != 2 || first.Changes[1].Path != "/siop-web/WebContent/execucao.xhtml" {
+		t.Errorf("first change set changes = %v", first.Changes)
+	}
+	// The deleted file has only a before state.
+	if len(second.Changes) != 1 || second.Changes[0].Path != "/siop-web/WebContent/antigo.xhtml" {
+		t.Errorf("deletion changes = %v, want the path of the before state", second.Changes)
+	}
+}
+
+func TestRTCClientError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+	client := &RTCClient{BaseURL: server.URL}
+	if _, err := client.Changesets(time.Now().AddDate(0, 0, -1), time.Now()); err == nil ||
+		!strings.Contains(err.Error(), "403") {
+		t.Errorf("err = %v, want the 403 status", err)
+	}
+}
@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CommandRunner runs the external tools (git, lscm) the extraction relies on.
+type CommandRunner interface {
+	// Output runs name in dir and returns its standard output.
+	Output(ctx context.Context, dir, name string, args ...string) ([]byte, error)
+	// Start runs name in dir, streaming its standard output. Closing the
+	// reader waits for the command, stopping it if it is still running.
+	Start(ctx context.Context, dir, name string, args ...string) (io.ReadCloser, error)
+}
+
+type CommandError struct {
+	Args     []string
+	Stderr   []byte
+	ExitCode int
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%v: exit status %v: %s", strings.Join(e.Args, " "), e.ExitCode,
+		bytes.TrimSpace(e.Stderr))
+}
+
+type ExecRunner struct{}
+
+func (ExecRunner) Output(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	return out, commandError(cmd, err, stderr)
+}
+
+func (ExecRunner) Start(ctx context.Context, dir, name string, args ...string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &execReader{ReadCloser: stdout, cmd: cmd, stderr: stderr}, nil
+}
+
+type execReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	eof    bool
+}
+
+func (r *execReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+func (r *execReader) Close() error {
+	if !r.eof {
+		r.cmd.Process.Kill()
+		r.cmd.Wait()
+		return nil
+	}
+	return commandError(r.cmd, r.cmd.Wait(), r.stderr)
+}
+
+func commandError(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return &CommandError{Args: cmd.Args, Stderr: stderr.Bytes(), ExitCode: exit.ExitCode()}
+	}
+	return err
+}
+
+// Recording is the fixture file saved for each command by a Recorder.
+type Recording struct {
+	Args     []string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Recorder runs commands with Runner, saving their outputs in Dir to be
+// served back by a Replayer.
+type Recorder struct {
+	Dir    string
+	Runner CommandRunner
+}
+
+func (r *Recorder) Output(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	out, err := r.Runner.Output(ctx, dir, name, args...)
+	rec := &Recording{Args: append([]string{name}, args...), Stdout: string(out)}
+	var cerr *CommandError
+	if errors.As(err, &cerr) {
+		rec.Stderr = string(cerr.Stderr)
+		rec.ExitCode = cerr.ExitCode
+	} else if err != nil {
+		return out, err
+	}
+	if werr := r.save(dir, rec); werr != nil {
+		return nil, werr
+	}
+	return out, err
+}
+
+func (r *Recorder) Start(ctx context.Context, dir, name string, args ...string) (io.ReadCloser, error) {
+	return startFromOutput(r.Output(ctx, dir, name, args...))
+}
+
+func (r *Recorder) save(dir string, rec *Recording) error {
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(recordingPath(r.Dir, dir, rec.Args))
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(rec)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Replayer serves the outputs saved by a Recorder without running anything.
+type Replayer struct {
+	Dir string
+}
+
+func (r *Replayer) Output(_ context.Context, dir, name string, args ...string) ([]byte, error) {
+	all := append([]string{name}, args...)
+	f, err := os.Open(recordingPath(r.Dir, dir, all))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recording of %q in %v", strings.Join(all, " "), r.Dir)
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rec := &Recording{}
+	if err := json.NewDecoder(f).Decode(rec); err != nil {
+		return nil, fmt.Errorf("error decoding recording %v: %v", f.Name(), err)
+	}
+	if rec.ExitCode != 0 {
+		return []byte(rec.Stdout), &CommandError{Args: all, Stderr: []byte(rec.Stderr),
+			ExitCode: rec.ExitCode}
+	}
+	return []byte(rec.Stdout), nil
+}
+
+func (r *Replayer) Start(ctx context.Context, dir, name string, args ...string) (io.ReadCloser, error) {
+	return startFromOutput(r.Output(ctx, dir, name, args...))
+}
+
+// startFromOutput serves out as a stream whose Close reports err, as an
+// exited command would.
+func startFromOutput(out []byte, err error) (io.ReadCloser, error) {
+	var cerr *CommandError
+	if err != nil && !errors.As(err, &cerr) {
+		return nil, err
+	}
+	return &outputReader{Reader: bytes.NewReader(out), err: err}, nil
+}
+
+type outputReader struct {
+	*bytes.Reader
+	err error
+}
+
+func (r *outputReader) Close() error {
+	return r.err
+}
+
+// recordingPath names recordings after the command line and the base name
+// of the directory it runs in, so that fixtures do not depend on where the
+// repositories are checked out.
+func recordingPath(recordings, dir string, args []string) string {
+	h := sha1.New()
+	io.WriteString(h, filepath.Base(dir))
+	for _, a := range args {
+		io.WriteString(h, "\x00"+a)
+	}
+	name := filepath.Base(args[0]) + "-" + hex.EncodeToString(h.Sum(nil))[:16] + ".json"
+	return filepath.Join(recordings, name)
+}
+
+// RunnerFlags selects the CommandRunner from the -record and -replay flags.
+type RunnerFlags struct {
+	Record string
+	Replay string
+}
+
+func (f *RunnerFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Record, "record", "", "directory to record the output of external commands to")
+	fs.StringVar(&f.Replay, "replay", "", "directory to replay recorded external commands from")
+}
+
+func (f RunnerFlags) Runner() CommandRunner {
+	switch {
+	case f.Replay != "":
+		return &Replayer{Dir: f.Replay}
+	case f.Record != "":
+		return &Recorder{Dir: f.Record, Runner: ExecRunner{}}
+	default:
+		return ExecRunner{}
+	}
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"sort"
+	"time"
+)
+
+// Session is a change session: consecutive commits by the same author on the
+// same issue, each made within the gap of the session from the previous one.
+type Session struct {
+	Author  string
+	Issue   string
+	Start   time.Time
+	End     time.Time
+	Commits int
+	Files   map[string]bool
+}
+
+// Sessionizer groups commits into change sessions, passing each session to
+// the closed function as it is closed: when its author commits on another
+// issue or after the gap. Only the last session of each author is kept.
+// Commits should be fed in time order; a commit out of order only joins a
+// session it falls within the gap of.
+type Sessionizer struct {
+	Gap    time.Duration
+	closed func(*Session)
+	open   map[string]*Session
+}
+
+func NewSessionizer(gap time.Duration, closed func(*Session)) *Sessionizer {
+	return &Sessionizer{Gap: gap, closed: closed, open: map[string]*Session{}}
+}
+
+func (s *Sessionizer) Add(c *Commit) {
+	t := c.Change.ModifiedTime
+	session := s.open[c.Change.Author]
+	if session != nil && (session.Issue != c.Issue.Id || t.Sub(session.End) > s.Gap ||
+		session.Start.Sub(t) > s.Gap) {
+		s.closed(session)
+		session = nil
+	}
+	if session == nil {
+		session = &Session{Author: c.Change.Author, Issue: c.Issue.Id, Start: t, End: t,
+			Files: map[string]bool{}}
+		s.open[c.Change.Author] = session
+	}
+	if t.Before(session.Start) {
+		session.Start = t
+	}
+	if t.After(session.End) {
+		session.End = t
+	}
+	session.Commits++
+	for _, f := range c.Files {
+		session.Files[f] = true
+	}
+}
+
+// Open returns the sessions not closed yet, the last one of each author, in
+// start order.
+func (s *Sessionizer) Open() []*Session {
+	sessions := make([]*Session, 0, len(s.open))
+	for _, session := range s.open {
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		a, b := sessions[i], sessions[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return a.Author < b.Author
+	})
+	return sessions
+}
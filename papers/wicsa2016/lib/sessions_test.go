@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var sessionsStart = time.Date(2012, 2, 15, 9, 0, 0, 0, time.UTC)
+
+// sessionCommit returns a commit of author on issue, minutes after 9:00.
+func sessionCommit(author, issue string, minutes int, files ...string) *Commit {
+	return &Commit{Issue: Issue{Id: issue}, Files: files, Change: &Change{Author: author,
+		ModifiedTime: sessionsStart.Add(time.Duration(minutes) * time.Minute)}}
+}
+
+func describeSession(s *Session) string {
+	return fmt.Sprintf("%v %v %v-%v %v", s.Author, s.Issue, s.Start.Sub(sessionsStart).Minutes(),
+		s.End.Sub(sessionsStart).Minutes(), s.Commits)
+}
+
+func TestSessionizer(t *testing.T) {
+	tests := []struct {
+		name    string
+		commits []*Commit
+		closed  []string
+		open    []string
+	}{
+		{"within the gap", []*Commit{sessionCommit("ana", "A", 0), sessionCommit("ana", "A", 50),
+			sessionCommit("ana", "A", 100)},
+			[]string{}, []string{"ana A 0-100 3"}},
+		{"beyond the gap", []*Commit{sessionCommit("ana", "A", 0), sessionCommit("ana", "A", 61)},
+			[]string{"ana A 0-0 1"}, []string{"ana A 61-61 1"}},
+		{"another issue in between", []*Commit{sessionCommit("ana", "A", 0), sessionCommit("ana", "B", 10),
+			sessionCommit("ana", "A", 20)},
+			[]string{"ana A 0-0 1", "ana B 10-10 1"}, []string{"ana A 20-20 1"}},
+		{"other authors in between", []*Commit{sessionCommit("ana", "A", 0), sessionCommit("rui", "B", 10),
+			sessionCommit("rui", "A", 15), sessionCommit("ana", "A", 20)},
+			[]string{"rui B 10-10 1"}, []string{"ana A 0-20 2", "rui A 15-15 1"}},
+		{"out of order", []*Commit{sessionCommit("ana", "A", 30), sessionCommit("ana", "A", 10),
+			sessionCommit("ana", "A", -5), sessionCommit("ana", "A", -70)},
+			[]string{"ana A -5-30 3"}, []string{"ana A -70--70 1"}},
+	}
+	for _, test := range tests {
+		closed := []string{}
+		s := NewSessionizer(time.Hour, func(session *Session) {
+			closed = append(closed, describeSession(session))
+		})
+		for _, c := range test.commits {
+			s.Add(c)
+		}
+		open := []string{}
+		for _, session := range s.Open() {
+			open = append(open, describeSession(session))
+		}
+		if !reflect.DeepEqual(closed, test.closed) || !reflect.DeepEqual(open, test.open) {
+			t.Errorf("%v: closed %q and open %q, want %q and %q", test.name, closed, open,
+				test.closed, test.open)
+		}
+	}
+}
+
+// Sessions are passed on as soon as they close, rather than kept to the end.
+func TestSessionizerStreams(t *testing.T) {
+	closed := 0
+	s := NewSessionizer(time.Hour, func(*Session) { closed++ })
+	for i := 0; i < 1000; i++ {
+		s.Add(sessionCommit("ana", fmt.Sprint(i%2), i))
+		if closed != i {
+			t.Fatalf("%v sessions closed after %v commits, want %v", closed, i+1, i)
+		}
+	}
+	if open := s.Open(); len(open) != 1 {
+		t.Errorf("%v sessions open, want the last one", len(open))
+	}
+}
+
+func TestAnalyzerSessions(t *testing.T) {
+	a := NewAnalyzer(LayerFunc(ofbizLayerExtractor))
+	a.SessionGap = time.Hour
+	for _, c := range []*Commit{
+		sessionCommit("ana", "OFBIZ-1", 0, "applications/order/entitydef/entitymodel.xml"),
+		sessionCommit("ana", "OFBIZ-1", 10, "applications/order/webapp/order.ftl"),
+		sessionCommit("ana", "OFBIZ-2", 20, "applications/order/src/OrderServices.java"),
+		sessionCommit("ana", "OFBIZ-1", 30, "applications/order/src/OrderServices.java"),
+		sessionCommit("rui", "OFBIZ-2", 40, "applications/order/src/OrderServices.java"),
+	} {
+		a.Add(c)
+	}
+	check := func() {
+		s := a.Stats()
+		if s.Sessions != 4 {
+			t.Errorf("%v sessions, want 4", s.Sessions)
+		}
+		if want := map[int]int{1: 3, 2: 1}; !reflect.DeepEqual(s.CommitsPerSession, want) {
+			t.Errorf("commits per session = %v, want %v", s.CommitsPerSession, want)
+		}
+		if want := map[int]int{2: 2}; !reflect.DeepEqual(s.SessionsPerIssue, want) {
+			t.Errorf("sessions per issue = %v, want %v", s.SessionsPerIssue, want)
+		}
+		if want := map[string]int{"mv": 1, "c": 3}; !reflect.DeepEqual(s.SessionsPerLayerCombination, want) {
+			t.Errorf("sessions per layer combination = %v, want %v", s.SessionsPerLayerCombination, want)
+		}
+	}
+	// the open sessions are not counted twice by repeated calls
+	check()
+	check()
+	a.Add(sessionCommit("rui", "OFBIZ-2", 50, "applications/order/src/OrderServices.java"))
+	if s := a.Stats(); s.Sessions != 4 || s.CommitsPerSession[2] != 2 {
+		t.Errorf("after extending a session: %v sessions, commits per session %v", s.Sessions,
+			s.CommitsPerSession)
+	}
+}
+
+func TestActivitySessions(t *testing.T) {
+	a := NewActivityAnalyzer()
+	a.SessionGap = time.Hour
+	for _, c := range []*Commit{
+		sessionCommit("ana", "OFBIZ-1", 0, "a", "b"),
+		sessionCommit("ana", "OFBIZ-1", 30, "b"),
+		sessionCommit("ana", "OFBIZ-2", 60, "c"),
+		sessionCommit("ana", "", 70, "d"),
+		sessionCommit("ana", "OFBIZ-1", 240, "a"),
+	} {
+		if err := a.Add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	activity := a.Activity()
+	// the sessions start at 0, 60 and 240; the unlinked commit forms none
+	if activity.Sessions != 3 || activity.CommitsPerSession != 4.0/3 || activity.FilesPerSession != 4.0/3 ||
+		activity.HoursPerSession != 0.5/3 || activity.HoursBetweenSessions != 2 {
+		t.Errorf("activity = %+v", activity)
+	}
+}
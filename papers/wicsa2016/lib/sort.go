@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+)
+
+// SortCommits returns a reader yielding the commits of r in modification time
+// order. At most window commits are held in memory, so the input must be
+// sorted up to that distance; a window of 0 buffers the whole stream.
+func SortCommits(r CommitReader, window int) CommitReader {
+	return &sortedReader{reader: r, window: window}
+}
+
+type sortedReader struct {
+	reader  CommitReader
+	window  int
+	pending commitHeap
+	last    *Commit
+	eof     bool
+}
+
+func (r *sortedReader) Read() (*Commit, error) {
+	for !r.eof && (r.window == 0 || len(r.pending) < r.window) {
+		c, err := r.reader.Read()
+		if err == io.EOF {
+			r.eof = true
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		heap.Push(&r.pending, c)
+	}
+	if len(r.pending) == 0 {
+		return nil, io.EOF
+	}
+	c := heap.Pop(&r.pending).(*Commit)
+	if r.last != nil && c.Change.ModifiedTime.Before(r.last.Change.ModifiedTime) {
+		return nil, fmt.Errorf("commit %v of %v is more than %v commits out of order",
+			c.Change.Uuid, c.Change.ModifiedTime, r.window)
+	}
+	r.last = c
+	return c, nil
+}
+
+func (r *sortedReader) Close() error {
+	return r.reader.Close()
+}
+
+type commitHeap []*Commit
+
+func (h commitHeap) Len() int { return len(h) }
+func (h commitHeap) Less(i, j int) bool {
+	return h[i].Change.ModifiedTime.Before(h[j].Change.ModifiedTime)
+}
+func (h commitHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *commitHeap) Push(x interface{}) { *h = append(*h, x.(*Commit)) }
+func (h *commitHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// SliceReader serves commits already in memory.
+type SliceReader []*Commit
+
+func (r *SliceReader) Read() (*Commit, error) {
+	if len(*r) == 0 {
+		return nil, io.EOF
+	}
+	c := (*r)[0]
+	*r = (*r)[1:]
+	return c, nil
+}
+
+func (r *SliceReader) Close() error {
+	return nil
+}
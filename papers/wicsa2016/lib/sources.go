@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// CommitReader iterates over commits; Read returns io.EOF after the last one.
+type CommitReader interface {
+	Read() (*Commit, error)
+	Close() error
+}
+
+type CommitSource interface {
+	Commits() (CommitReader, error)
+}
+
+type IssueSource interface {
+	Issues() (*WorkItemGraph, error)
+}
+
+type LayerClassifier interface {
+	Layer(file string) string
+}
+
+type LayerFunc func(string) string
+
+func (f LayerFunc) Layer(file string) string {
+	return f(file)
+}
+
+// JSONCommitSource reads the commits written by consolidate, either as a
+// JSON array or as a sequence of JSON objects, one at a time.
+type JSONCommitSource struct {
+	File      string
+	Options   Options
+	Policies  Policies
+	Zones     Zones
+	Corrected map[string]string
+}
+
+func (s *JSONCommitSource) Commits() (CommitReader, error) {
+	if s.File == "" {
+		return nil, ErrNoCommitsFile
+	}
+	if err := s.Policies.check(); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(s.File)
+	if err != nil {
+		return nil, fmt.Errorf("error opening commits file: %v", err)
+	}
+	r := bufio.NewReader(file)
+	array, err := startsWithArray(r)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading commits file %v: %v", s.File, err)
+	}
+	d := json.NewDecoder(r)
+	if array {
+		if _, err := d.Token(); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error decoding commits file %v: %v", s.File, err)
+		}
+	}
+	return &jsonCommitReader{file: file, decoder: d, source: s}, nil
+}
+
+func startsWithArray(r *bufio.Reader) (bool, error) {
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		default:
+			return b[0] == '[', nil
+		}
+	}
+}
+
+type jsonCommitReader struct {
+	file    *os.File
+	decoder *json.Decoder
+	source  *JSONCommitSource
+}
+
+func (r *jsonCommitReader) Read() (*Commit, error) {
+	for r.decoder.More() {
+		c := &Commit{}
+		if err := r.decoder.Decode(c); err != nil {
+			return nil, fmt.Errorf("error decoding commits file %v: %v", r.file.Name(), err)
+		}
+		s := r.source
+		if err := s.Zones.parseTimes(c.Change); err != nil {
+			return nil, err
+		}
+		if c.Change.CoAuthors == nil {
+			c.Change.CoAuthors = CoAuthors(c.Change.Comment)
+		}
+		if c.Issue.Id != "" {
+			c.Issue.Kind = siopKind(correctedKind(s.Corrected, c.Issue.Id, c.Issue.Kind))
+		}
+		if s.Options.keep(c) && s.Policies.screen(c) {
+			s.Zones.normalize(c)
+			return c, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// siopKind spells kind, which may be corrected, as the commits file does:
+// bug, or story for any other kind.
+func siopKind(kind string) string {
+	if strings.EqualFold(kind, "bug") {
+		return "bug"
+	}
+	return "story"
+}
+
+func (r *jsonCommitReader) Close() error {
+	return r.file.Close()
+}
+
+// CSVIssueSource reads the issues file written by the fetcher.
+type CSVIssueSource string
+
+func (file CSVIssueSource) Issues() (*WorkItemGraph, error) {
+	if file == "" {
+		return nil, ErrNoIssuesFile
+	}
+	return LoadJiraWorkItems(string(file))
+}
+
+func ReadAll(r CommitReader) ([]*Commit, error) {
+	commits := []*Commit{}
+	for {
+		c, err := r.Read()
+		if err == io.EOF {
+			return commits, nil
+		} else if err != nil {
+			return nil, err
+		}
+		commits = append(commits, c)
+	}
+}
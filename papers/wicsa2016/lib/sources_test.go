@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const siopCommits = `[
+{"Issue":{"Id":"1001","Kind":"bug"},"Change":{"author":"ana","comment":"fix total","modified":"15/02/2012 10:00"},
+ "Files":["/siop-ejb/Total.java"]},
+{"Issue":{"Id":"1002","Kind":"story"},"Change":{"author":"ana","comment":"new screen","modified":"15/02/2012 11:00"},
+ "Files":["/siop-war/screen.xhtml"]},
+{"Issue":{"Id":"1003","Kind":"bug"},"Change":{"author":"rui","comment":"new report","modified":"15/02/2012 12:00"},
+ "Files":["/siop-war/report.xhtml"]},
+{"Issue":{"Id":"","Kind":""},"Change":{"author":"rui","comment":"build","modified":"15/02/2012 13:00"},
+ "Files":["/siop-ejb/pom.xml"]}
+]`
+
+// The model spells the corrected kinds as it learned them, in any case.
+const siopCorrections = `Key,Type,Corrected Type,Probability
+1002,story,Bug,0.9
+1003,bug,STORY,0.8
+`
+
+func TestJSONCommitSourceKinds(t *testing.T) {
+	dir := t.TempDir()
+	commits, corrections := filepath.Join(dir, "commits.json"), filepath.Join(dir, "corrections.csv")
+	ioutil.WriteFile(commits, []byte(siopCommits), 0644)
+	ioutil.WriteFile(corrections, []byte(siopCorrections), 0644)
+	tests := []struct {
+		kind, corrections string
+		want              []string
+	}{
+		{"", "", []string{"1001 bug", "1002 story", "1003 bug", " "}},
+		{"bug", "", []string{"1001 bug", "1003 bug"}},
+		{"", corrections, []string{"1001 bug", "1002 bug", "1003 story", " "}},
+		{"bug", corrections, []string{"1001 bug", "1002 bug"}},
+		{"story", corrections, []string{"1003 story"}},
+	}
+	for _, test := range tests {
+		system, _ := LookupSystem("siop")
+		flags := SourceFlags{Options: Options{CommitsFile: commits, IssueKind: test.kind},
+			Corrections: test.corrections}
+		config, err := flags.Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := system.Source(flags.Options, config).Commits()
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// as stats does
+		a := NewAnalyzer(system.Layers)
+		got := []string{}
+		for _, c := range read {
+			got = append(got, c.Issue.Id+" "+c.Issue.Kind)
+			a.Add(c)
+		}
+		if stats := a.Stats(); stats.Commits != len(test.want) {
+			t.Errorf("-k %q -corrected %q: %v commits analyzed, want %v", test.kind, test.corrections,
+				stats.Commits, len(test.want))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("-k %q -corrected %q: issues %q, want %q", test.kind, test.corrections, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"time"
+)
+
+type Stats struct {
+	Commits                     int
+	CommitsWithIssues           int
+	Features                    int
+	Issues                      int
+	Files                       map[string]int
+	CommitsPerLayerCombination  map[string]int
+	LayersPerCommit             map[int]int
+	UsersPerIssue               map[int]int
+	CommitsPerIssue             map[int]int
+	LayersPerIssue              map[int]int
+	IssuesPerLayerCombination   map[string]int
+	UsersPerFeature             map[int]int
+	CommitsPerFeature           map[int]int
+	LayersPerFeature            map[int]int
+	IssuesPerFeature            map[int]int
+	FeaturesPerLayerCombination map[string]int
+	Epics                       int
+	UsersPerEpic                map[int]int
+	CommitsPerEpic              map[int]int
+	LayersPerEpic               map[int]int
+	IssuesPerEpic               map[int]int
+	FeaturesPerEpic             map[int]int
+	EpicsPerLayerCombination    map[string]int
+	UnlinkedCommits             int
+	UnlinkedPerCategory         map[string]int
+	LayersPerUnlinkedCommit     map[string]map[int]int
+	UnlinkedPerLayerCombination map[string]int
+	CommitsWithoutFeature       int
+	CommitsWithoutEpic          int
+	LinkedCommitRate            float64
+	TrackedIssues               int
+	IssuesWithCommits           int
+	IssuesWithCommitsRate       float64
+	FlaggedCommits              map[string]int
+	LayersPerFlaggedCommit      map[string]map[int]int
+	TangledCommits              int
+	PartsPerTangledCommit       map[int]int
+	KeysPerTangledCommit        map[int]int
+	TangledPerLayerCombination  map[string]int
+	Sessions                    int
+	CommitsPerSession           map[int]int
+	LayersPerSession            map[int]int
+	SessionsPerIssue            map[int]int
+	SessionsPerLayerCombination map[string]int
+}
+
+// UnlinkedPolicy tells how Analyzer counts the commits without issue, which
+// are always counted by category of ClassifyCommit too.
+type UnlinkedPolicy int
+
+const (
+	// SeparateUnlinked counts them in the distributions per commit only.
+	SeparateUnlinked UnlinkedPolicy = iota
+	// ExcludeUnlinked counts them by category only.
+	ExcludeUnlinked
+	// PseudoIssue counts them as the commits of an issue with empty id, as
+	// earlier versions did.
+	PseudoIssue
+)
+
+var unlinkedPolicies = map[string]UnlinkedPolicy{
+	"separate": SeparateUnlinked, "exclude": ExcludeUnlinked, "pseudo": PseudoIssue}
+
+func ParseUnlinkedPolicy(s string) (UnlinkedPolicy, error) {
+	p, ok := unlinkedPolicies[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown unlinked commits policy %q (choose among separate, exclude, pseudo)", s)
+	}
+	return p, nil
+}
+
+// Group accumulates the commits of an issue, a feature or an epic.
+type Group struct {
+	Commits  int
+	Files    int
+	Issues   map[string]int
+	Features map[string]int
+	Layers   map[string]int
+	Users    map[string]int
+}
+
+func newGroup() *Group {
+	return &Group{Issues: map[string]int{}, Features: map[string]int{},
+		Layers: map[string]int{}, Users: map[string]int{}}
+}
+
+// Analyzer computes the layer distributions of a sequence of commits. Commits
+// are fed one at a time with Add; Stats summarizes what was seen so far.
+// Commits without feature or epic form no group unless EmptyGroups is set.
+// Tracker, when set, gives the issues whose share having commits is reported.
+// Tangles, when set, detects the tangled commits, which TangledPolicy keeps,
+// splits or excludes in the distributions per commit, and which are passed
+// to OnTangled. SessionGap, when not zero, groups the commits into change
+// sessions (see Sessionizer), whose distributions are computed too. Users
+// is the definition of the users counted per issue, feature and epic (see
+// Change.Users), their authors if empty.
+type Analyzer struct {
+	Layers           LayerClassifier
+	MinimumFileCount int
+	Unlinked         UnlinkedPolicy
+	EmptyGroups      bool
+	Tracker          *WorkItemGraph
+	Tangles          *TangleDetector
+	TangledPolicy    string
+	OnTangled        func(*Commit, Tangle)
+	SessionGap       time.Duration
+	Users            string
+	sessions         *Sessionizer
+	sessionsPerIssue map[string]int
+	stats            Stats
+	kinds            map[string]int
+	issues           map[string]*Group
+	features         map[string]*Group
+	epics            map[string]*Group
+}
+
+func NewAnalyzer(layers LayerClassifier) *Analyzer {
+	return &Analyzer{
+		Layers: layers,
+		stats: Stats{
+			Files:                       map[string]int{},
+			CommitsPerLayerCombination:  map[string]int{},
+			LayersPerCommit:             map[int]int{},
+			UnlinkedPerCategory:         map[string]int{},
+			LayersPerUnlinkedCommit:     map[string]map[int]int{},
+			UnlinkedPerLayerCombination: map[string]int{},
+			FlaggedCommits:              map[string]int{},
+			LayersPerFlaggedCommit:      map[string]map[int]int{},
+			PartsPerTangledCommit:       map[int]int{},
+			KeysPerTangledCommit:        map[int]int{},
+			TangledPerLayerCombination:  map[string]int{},
+			CommitsPerSession:           map[int]int{},
+			LayersPerSession:            map[int]int{},
+			SessionsPerLayerCombination: map[string]int{}},
+		sessionsPerIssue: map[string]int{},
+		kinds:            map[string]int{},
+		issues:           map[string]*Group{},
+		features:         map[string]*Group{},
+		epics:            map[string]*Group{}}
+}
+
+func (a *Analyzer) Add(commit *Commit) {
+	var tangle Tangle
+	if a.Tangles != nil {
+		tangle = a.Tangles.Detect(commit)
+	}
+	a.kinds[commit.Issue.Kind]++
+	a.stats.Commits++
+	linked := commit.Issue.Id != ""
+	if linked {
+		a.stats.CommitsWithIssues++
+	} else {
+		a.addUnlinked(commit)
+		if a.Unlinked == ExcludeUnlinked {
+			return
+		}
+	}
+	if a.SessionGap > 0 && (linked || a.Unlinked == PseudoIssue) {
+		if a.sessions == nil {
+			a.sessions = NewSessionizer(a.SessionGap, func(session *Session) {
+				a.addSession(&a.stats, a.sessionsPerIssue, session)
+			})
+		}
+		a.sessions.Add(commit)
+	}
+	groups := []*Group{}
+	issues := map[string]int{}
+	if linked || a.Unlinked == PseudoIssue {
+		groups = append(groups, a.group(a.issues, commit.Issue.Id))
+		issues[commit.Issue.Id] = 0
+	}
+	if commit.Feature != "" || a.EmptyGroups {
+		feature := a.group(a.features, commit.Feature)
+		for id := range issues {
+			feature.Issues[id] = 0
+		}
+		groups = append(groups, feature)
+	} else if len(issues) > 0 {
+		a.stats.CommitsWithoutFeature++
+	}
+	if commit.Epic != "" || a.EmptyGroups {
+		epic := a.group(a.epics, commit.Epic)
+		for id := range issues {
+			epic.Issues[id] = 0
+		}
+		if commit.Feature != "" || a.EmptyGroups {
+			epic.Features[commit.Feature] = 0
+		}
+		groups = append(groups, epic)
+	} else if len(issues) > 0 {
+		a.stats.CommitsWithoutEpic++
+	}
+	users := commit.Change.Users(a.Users)
+	for _, g := range groups {
+		g.Commits++
+		for _, u := range users {
+			g.Users[u] = 0
+		}
+	}
+	layers := map[string]int{}
+	for _, file := range commit.Files {
+		layer := a.Layers.Layer(file)
+		if layer != "" {
+			layers[layer] = 0
+			for _, g := range groups {
+				g.Layers[layer] = 0
+				g.Files++
+			}
+			a.stats.Files[layer]++
+		}
+	}
+	if !tangle.Tangled() {
+		a.stats.LayersPerCommit[len(layers)]++
+		a.stats.CommitsPerLayerCombination[Combination(layers)]++
+	} else {
+		a.addTangled(commit, tangle, layers)
+	}
+	for _, f := range commit.Flags {
+		a.stats.FlaggedCommits[f]++
+		if a.stats.LayersPerFlaggedCommit[f] == nil {
+			a.stats.LayersPerFlaggedCommit[f] = map[int]int{}
+		}
+		a.stats.LayersPerFlaggedCommit[f][len(layers)]++
+	}
+}
+
+func (a *Analyzer) addTangled(commit *Commit, tangle Tangle, layers map[string]int) {
+	a.stats.TangledCommits++
+	a.stats.PartsPerTangledCommit[len(tangle.Parts)]++
+	a.stats.KeysPerTangledCommit[len(tangle.Keys)]++
+	a.stats.TangledPerLayerCombination[Combination(layers)]++
+	if a.OnTangled != nil {
+		a.OnTangled(commit, tangle)
+	}
+	switch a.TangledPolicy {
+	case SplitTangled:
+		for _, part := range tangle.Parts {
+			partLayers := a.layers(part)
+			a.stats.LayersPerCommit[len(partLayers)]++
+			a.stats.CommitsPerLayerCombination[Combination(partLayers)]++
+		}
+	case ExcludeTangled:
+		// counted as tangled only
+	default:
+		a.stats.LayersPerCommit[len(layers)]++
+		a.stats.CommitsPerLayerCombination[Combination(layers)]++
+	}
+}
+
+// layers returns the layers of files.
+func (a *Analyzer) layers(files []string) map[string]int {
+	layers := map[string]int{}
+	for _, file := range files {
+		if layer := a.Layers.Layer(file); layer != "" {
+			layers[layer] = 0
+		}
+	}
+	return layers
+}
+
+func (a *Analyzer) addUnlinked(commit *Commit) {
+	category := ClassifyCommit(commit)
+	layers := a.layers(commit.Files)
+	a.stats.UnlinkedCommits++
+	a.stats.UnlinkedPerCategory[category]++
+	if a.stats.LayersPerUnlinkedCommit[category] == nil {
+		a.stats.LayersPerUnlinkedCommit[category] = map[int]int{}
+	}
+	a.stats.LayersPerUnlinkedCommit[category][len(layers)]++
+	a.stats.UnlinkedPerLayerCombination[Combination(layers)]++
+}
+
+func (a *Analyzer) group(groups map[string]*Group, key string) *Group {
+	g, ok := groups[key]
+	if !ok {
+		g = newGroup()
+		groups[key] = g
+	}
+	return g
+}
+
+// Kinds counts the commits per issue kind.
+func (a *Analyzer) Kinds() map[string]int {
+	return a.kinds
+}
+
+func (a *Analyzer) Issues() map[string]*Group {
+	return a.issues
+}
+
+func (a *Analyzer) Features() map[string]*Group {
+	return a.features
+}
+
+func (a *Analyzer) Epics() map[string]*Group {
+	return a.epics
+}
+
+// Counted reports whether g passes the minimum file count.
+func (a *Analyzer) Counted(g *Group) bool {
+	return a.MinimumFileCount == 0 || g.Files >= a.MinimumFileCount
+}
+
+func (a *Analyzer) Stats() *Stats {
+	s := a.stats
+	s.UsersPerIssue = map[int]int{}
+	s.CommitsPerIssue = map[int]int{}
+	s.LayersPerIssue = map[int]int{}
+	s.IssuesPerLayerCombination = map[string]int{}
+	s.UsersPerFeature = map[int]int{}
+	s.CommitsPerFeature = map[int]int{}
+	s.LayersPerFeature = map[int]int{}
+	s.IssuesPerFeature = map[int]int{}
+	s.FeaturesPerLayerCombination = map[string]int{}
+	s.UsersPerEpic = map[int]int{}
+	s.CommitsPerEpic = map[int]int{}
+	s.LayersPerEpic = map[int]int{}
+	s.IssuesPerEpic = map[int]int{}
+	s.FeaturesPerEpic = map[int]int{}
+	s.EpicsPerLayerCombination = map[string]int{}
+	for _, f := range a.features {
+		if a.Counted(f) {
+			s.Features++
+			s.CommitsPerFeature[f.Commits]++
+			s.UsersPerFeature[len(f.Users)]++
+			s.LayersPerFeature[len(f.Layers)]++
+			s.IssuesPerFeature[len(f.Issues)]++
+			s.FeaturesPerLayerCombination[Combination(f.Layers)]++
+		}
+	}
+	for _, i := range a.issues {
+		if a.Counted(i) {
+			s.Issues++
+			s.CommitsPerIssue[i.Commits]++
+			s.UsersPerIssue[len(i.Users)]++
+			s.LayersPerIssue[len(i.Layers)]++
+			s.IssuesPerLayerCombination[Combination(i.Layers)]++
+		}
+	}
+	for _, e := range a.epics {
+		if a.Counted(e) {
+			s.Epics++
+			s.CommitsPerEpic[e.Commits]++
+			s.UsersPerEpic[len(e.Users)]++
+			s.LayersPerEpic[len(e.Layers)]++
+			s.IssuesPerEpic[len(e.Issues)]++
+			s.FeaturesPerEpic[len(e.Features)]++
+			s.EpicsPerLayerCombination[Combination(e.Layers)]++
+		}
+	}
+	// the sessions still open are counted on copies, to be counted once
+	// more when they close
+	s.CommitsPerSession = maps.Clone(a.stats.CommitsPerSession)
+	s.LayersPerSession = maps.Clone(a.stats.LayersPerSession)
+	s.SessionsPerLayerCombination = maps.Clone(a.stats.SessionsPerLayerCombination)
+	perIssue := maps.Clone(a.sessionsPerIssue)
+	if a.sessions != nil {
+		for _, session := range a.sessions.Open() {
+			a.addSession(&s, perIssue, session)
+		}
+	}
+	s.SessionsPerIssue = map[int]int{}
+	for _, n := range perIssue {
+		s.SessionsPerIssue[n]++
+	}
+	if s.Commits > 0 {
+		s.LinkedCommitRate = float64(s.CommitsWithIssues) / float64(s.Commits)
+	}
+	for id := range a.issues {
+		if id != "" && (a.Tracker == nil || a.Tracker.Item(id) != nil) {
+			s.IssuesWithCommits++
+		}
+	}
+	if a.Tracker != nil && a.Tracker.Len() > 0 {
+		s.TrackedIssues = a.Tracker.Len()
+		s.IssuesWithCommitsRate = float64(s.IssuesWithCommits) / float64(s.TrackedIssues)
+	}
+	return &s
+}
+
+// addSession counts session in s and in the sessions of its issue.
+func (a *Analyzer) addSession(s *Stats, perIssue map[string]int, session *Session) {
+	files := make([]string, 0, len(session.Files))
+	for f := range session.Files {
+		files = append(files, f)
+	}
+	layers := a.layers(files)
+	s.Sessions++
+	s.CommitsPerSession[session.Commits]++
+	s.LayersPerSession[len(layers)]++
+	s.SessionsPerLayerCombination[Combination(layers)]++
+	perIssue[session.Issue]++
+}
+
+func Analyze(r CommitReader, layers LayerClassifier, minimumFileCount int) (*Stats, error) {
+	a := NewAnalyzer(layers)
+	a.MinimumFileCount = minimumFileCount
+	for {
+		c, err := r.Read()
+		if err == io.EOF {
+			return a.Stats(), nil
+		} else if err != nil {
+			return nil, err
+		}
+		a.Add(c)
+	}
+}
+
+func Combination(layers map[string]int) string {
+	_, m := layers["m"]
+	_, v := layers["v"]
+	_, c := layers["c"]
+	switch {
+	case m && v && c:
+		return "mvc"
+	case m && v:
+		return "mv"
+	case m && c:
+		return "mc"
+	case v && c:
+		return "vc"
+	case m:
+		return "m"
+	case v:
+		return "v"
+	case c:
+		return "c"
+	default:
+		return ""
+	}
+}
@@ -8,25 +8,32 @@ type Issue struct {
 
 type Commit struct {
 	Feature string
+	Epic    string
 	Issue   Issue
 	Change  *Change
 	Files   []string
+	Flags   []string `json:",omitempty"`
 }
 
 type Changeset struct {
-	Changes []Change `json:changes`
+	Changes []Change `json:"changes"`
 }
 
 type Change struct {
-	Author       string `json:author`
-	Comment      string `json:comment`
-	Modified     string `json:Modified`
-	ModifiedTime time.Time
-	Uuid         string `json:uuid`
-	Changes      []File `json:changes`
-	Uuids        []string
+	Author        string `json:"author"`
+	Comment       string `json:"comment"`
+	Modified      string `json:"modified"`
+	ModifiedTime  time.Time
+	Committed     string `json:"committed,omitempty"`
+	CommittedTime time.Time
+	Committer     string   `json:"committer,omitempty"`
+	CoAuthors     []string `json:"coauthors,omitempty"`
+	Uuid          string   `json:"uuid"`
+	Changes       []File   `json:"changes"`
+	Uuids         []string
+	Parents       []string `json:"parents,omitempty"`
 }
 
 type File struct {
-	Path string `json:path`
+	Path string `json:"path"`
 }
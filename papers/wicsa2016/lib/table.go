@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Column is a column of an exported table, found by its header Name or any
+// of its Aliases, such as the names used by localized exports.
+type Column struct {
+	Name     string
+	Aliases  []string
+	Required bool
+}
+
+type Schema struct {
+	Name    string
+	Columns []Column
+}
+
+// WithAliases returns a copy of s whose columns also accept the aliases
+// given by column name.
+func (s Schema) WithAliases(aliases map[string][]string) Schema {
+	columns := make([]Column, len(s.Columns))
+	for i, c := range s.Columns {
+		c.Aliases = append(append([]string{}, c.Aliases...), aliases[c.Name]...)
+		columns[i] = c
+	}
+	return Schema{Name: s.Name, Columns: columns}
+}
+
+// LoadAliases reads a JSON object mapping column names to lists of aliases.
+func LoadAliases(file string) (map[string][]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	aliases := map[string][]string{}
+	if err := json.NewDecoder(f).Decode(&aliases); err != nil {
+		return nil, fmt.Errorf("error decoding column aliases %v: %v", file, err)
+	}
+	return aliases, nil
+}
+
+type RowError struct {
+	Table string
+	Line  int
+	Err   error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("%v:%v: %v", e.Table, e.Line, e.Err)
+}
+
+// TableReader reads the rows of a CSV export, locating the columns of its
+// schema by the header.
+type TableReader struct {
+	schema  Schema
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func NewTableReader(r io.Reader, schema Schema) (*TableReader, error) {
+	t := &TableReader{schema: schema, reader: csv.NewReader(r), columns: map[string]int{}}
+	header, err := t.reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%v: missing header", schema.Name)
+	} else if err != nil {
+		return nil, fmt.Errorf("%v: %v", schema.Name, err)
+	}
+	positions := map[string]int{}
+	for i, h := range header {
+		positions[normalizeHeader(h)] = i
+	}
+	missing := []string{}
+	for _, c := range schema.Columns {
+		for _, name := range append([]string{c.Name}, c.Aliases...) {
+			if i, ok := positions[normalizeHeader(name)]; ok {
+				t.columns[c.Name] = i
+				break
+			}
+		}
+		if _, ok := t.columns[c.Name]; !ok && c.Required {
+			missing = append(missing, c.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%v: missing columns %v in header %q", schema.Name,
+			strings.Join(missing, ", "), header)
+	}
+	return t, nil
+}
+
+func normalizeHeader(h string) string {
+	return accents.Replace(strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))))
+}
+
+type Row struct {
+	Line   int
+	table  *TableReader
+	record []string
+}
+
+// Read returns the next row, or io.EOF after the last one. Malformed rows are
+// reported as a *RowError.
+func (t *TableReader) Read() (*Row, error) {
+	record, err := t.reader.Read()
+	if err == io.EOF {
+		return nil, err
+	} else if perr, ok := err.(*csv.ParseError); ok {
+		return nil, &RowError{Table: t.schema.Name, Line: perr.Line, Err: perr.Err}
+	} else if err != nil {
+		return nil, fmt.Errorf("%v: %v", t.schema.Name, err)
+	}
+	line, _ := t.reader.FieldPos(0)
+	return &Row{Line: line, table: t, record: record}, nil
+}
+
+// Has reports whether the export has the column.
+func (t *TableReader) Has(column string) bool {
+	_, ok := t.columns[column]
+	return ok
+}
+
+// Get returns the value of column, or "" if the export does not have it.
+func (r *Row) Get(column string) string {
+	i, ok := r.table.columns[column]
+	if !ok {
+		if r.table.schema.column(column) == nil {
+			panic("lib: column " + column + " not in schema " + r.table.schema.Name)
+		}
+		return ""
+	}
+	return r.record[i]
+}
+
+// Error returns a *RowError for the row.
+func (r *Row) Error(format string, args ...interface{}) error {
+	return &RowError{Table: r.table.schema.Name, Line: r.Line, Err: fmt.Errorf(format, args...)}
+}
+
+func (s Schema) column(name string) *Column {
+	for i := range s.Columns {
+		if s.Columns[i].Name == name {
+			return &s.Columns[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Tangle is the estimate of the unrelated changes bundled in a commit: the
+// groups of files not related by directory or co-change history, and the
+// issue keys of the message.
+type Tangle struct {
+	Parts [][]string
+	Keys  []string
+}
+
+func (t Tangle) Tangled() bool {
+	return len(t.Parts) > 1 || len(t.Keys) > 1
+}
+
+// TangleDetector estimates how tangled commits are from the history of the
+// commits fed before them, so they must be fed in time order. The files of
+// a commit are clustered by their directories, Depth components deep, and
+// two clusters are unrelated only when both were changed by at least
+// MinHistory earlier commits but never together: clusters without history
+// are related. IssueKeys lists the issue keys of a message, the issue of
+// the commit being its only key if nil.
+type TangleDetector struct {
+	Depth      int
+	MinHistory int
+	// MaxClusters bounds the commits learned from, as bulk changes relate
+	// everything.
+	MaxClusters int
+	IssueKeys   func(string) []string
+	changes     map[string]int
+	cochanges   map[[2]string]int
+}
+
+func NewTangleDetector(issueKeys func(string) []string) *TangleDetector {
+	return &TangleDetector{Depth: 3, MinHistory: 5, MaxClusters: 20, IssueKeys: issueKeys,
+		changes: map[string]int{}, cochanges: map[[2]string]int{}}
+}
+
+// Detect estimates the tangle of c and learns its co-changes.
+func (d *TangleDetector) Detect(c *Commit) Tangle {
+	t := Tangle{Keys: []string{}}
+	if d.IssueKeys != nil {
+		t.Keys = distinct(d.IssueKeys(c.Change.Comment))
+	} else if c.Issue.Id != "" {
+		t.Keys = []string{c.Issue.Id}
+	}
+	files := map[string][]string{}
+	for _, f := range c.Files {
+		cluster := d.cluster(f)
+		files[cluster] = append(files[cluster], f)
+	}
+	clusters := make([]string, 0, len(files))
+	for k := range files {
+		clusters = append(clusters, k)
+	}
+	sort.Strings(clusters)
+	parent := map[string]string{}
+	var find func(string) string
+	find = func(k string) string {
+		if parent[k] == "" || parent[k] == k {
+			return k
+		}
+		parent[k] = find(parent[k])
+		return parent[k]
+	}
+	for i, a := range clusters {
+		for _, b := range clusters[i+1:] {
+			if d.related(a, b) {
+				parent[find(b)] = find(a)
+			}
+		}
+	}
+	parts := map[string][]string{}
+	roots := []string{}
+	for _, k := range clusters {
+		root := find(k)
+		if parts[root] == nil {
+			roots = append(roots, root)
+		}
+		parts[root] = append(parts[root], files[k]...)
+	}
+	for _, r := range roots {
+		t.Parts = append(t.Parts, parts[r])
+	}
+	if len(clusters) <= d.MaxClusters {
+		for i, a := range clusters {
+			d.changes[a]++
+			for _, b := range clusters[i+1:] {
+				d.cochanges[[2]string{a, b}]++
+			}
+		}
+	}
+	return t
+}
+
+func (d *TangleDetector) related(a, b string) bool {
+	return d.cochanges[[2]string{a, b}] > 0 || d.changes[a] < d.MinHistory ||
+		d.changes[b] < d.MinHistory
+}
+
+func (d *TangleDetector) cluster(file string) string {
+	dir := strings.Split(path.Dir(file), "/")
+	if len(dir) > d.Depth {
+		dir = dir[:d.Depth]
+	}
+	return strings.Join(dir, "/")
+}
+
+func distinct(values []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// Tangled commit policies: keep them as they are, split them into their
+// parts or exclude them from the distributions per commit.
+const (
+	KeepTangled    = "keep"
+	SplitTangled   = "split"
+	ExcludeTangled = "exclude"
+)
+
+func CheckTangledPolicy(policy string) error {
+	switch policy {
+	case KeepTangled, SplitTangled, ExcludeTangled:
+		return nil
+	}
+	return fmt.Errorf("unknown tangled commits policy %q (choose among keep, split, exclude)", policy)
+}
@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	orderModel      = "applications/order/entitydef/entitymodel.xml"
+	orderView       = "applications/order/webapp/order/order.ftl"
+	orderService    = "applications/order/src/org/ofbiz/order/OrderServices.java"
+	orderTest       = "applications/order/src/org/ofbiz/order/test/OrderTests.java"
+	invoiceView     = "applications/accounting/webapp/accounting/invoice.ftl"
+	invoiceService  = "applications/accounting/src/org/ofbiz/accounting/InvoiceServices.java"
+	frameworkSource = "framework/base/src/org/ofbiz/base/util/UtilMisc.java"
+)
+
+// tangleStream is a history in which the order and the invoice directories
+// are each changed twice on their own before a commit changes both.
+var tangleStream = []struct {
+	message string
+	files   []string
+	parts   [][]string
+	keys    []string
+}{
+	// the directories of a commit without history are related
+	{"OFBIZ-1 order entity", []string{orderModel, orderService, orderTest, orderView},
+		[][]string{{orderModel, orderService, orderTest, orderView}}, []string{"OFBIZ-1"}},
+	{"OFBIZ-1 order fields", []string{orderModel, orderService, orderView},
+		[][]string{{orderModel, orderService, orderView}}, []string{"OFBIZ-1"}},
+	{"OFBIZ-2 invoice screen", []string{invoiceView, invoiceService},
+		[][]string{{invoiceService, invoiceView}}, []string{"OFBIZ-2"}},
+	{"OFBIZ-2 invoice totals", []string{invoiceView, invoiceService},
+		[][]string{{invoiceService, invoiceView}}, []string{"OFBIZ-2"}},
+	// order and invoice directories were never changed together
+	{"OFBIZ-3 order and invoice", []string{orderModel, invoiceView, invoiceService},
+		[][]string{{invoiceService, invoiceView}, {orderModel}}, []string{"OFBIZ-3"}},
+	// one directory, but two issues
+	{"OFBIZ-1 OFBIZ-2 order for OFBIZ-1", []string{orderView},
+		[][]string{{orderView}}, []string{"OFBIZ-1", "OFBIZ-2"}},
+	// related since the last but one commit
+	{"OFBIZ-3 order and invoice again", []string{orderModel, invoiceView},
+		[][]string{{invoiceView, orderModel}}, []string{"OFBIZ-3"}},
+	// the framework has no history
+	{"OFBIZ-4 order utilities", []string{orderView, frameworkSource},
+		[][]string{{orderView, frameworkSource}}, []string{"OFBIZ-4"}},
+}
+
+func tangleCommits() []*Commit {
+	commits := []*Commit{}
+	for _, c := range tangleStream {
+		commits = append(commits, &Commit{Issue: Issue{Id: ofbizIssueExtractor(c.message)},
+			Change: &Change{Author: "ana", Comment: c.message}, Files: c.files})
+	}
+	return commits
+}
+
+func newTestTangleDetector() *TangleDetector {
+	d := NewTangleDetector(ofbizIssueKeys)
+	d.MinHistory = 2
+	return d
+}
+
+func TestTangleDetector(t *testing.T) {
+	d := newTestTangleDetector()
+	for i, c := range tangleCommits() {
+		want := Tangle{Parts: tangleStream[i].parts, Keys: tangleStream[i].keys}
+		if got := d.Detect(c); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: tangle %v, want %v", c.Change.Comment, got, want)
+		}
+	}
+}
+
+func TestTangleDetectorIssueKeys(t *testing.T) {
+	c := &Commit{Issue: Issue{Id: "OFBIZ-7"}, Change: &Change{Comment: "OFBIZ-7 OFBIZ-7 and OFBIZ-8"},
+		Files: []string{orderView}}
+	if keys := NewTangleDetector(ofbizIssueKeys).Detect(c).Keys; !reflect.DeepEqual(keys, []string{"OFBIZ-7", "OFBIZ-8"}) {
+		t.Errorf("keys = %v, want the distinct keys of the message", keys)
+	}
+	if keys := NewTangleDetector(nil).Detect(c).Keys; !reflect.DeepEqual(keys, []string{"OFBIZ-7"}) {
+		t.Errorf("keys without extractor = %v, want the issue of the commit", keys)
+	}
+}
+
+// Bulk commits are not learned from, as they would relate every directory.
+func TestTangleDetectorIgnoresBulkCommits(t *testing.T) {
+	d := newTestTangleDetector()
+	d.MaxClusters = 2
+	bulk := &Commit{Change: &Change{}, Files: []string{orderModel, orderView, invoiceView}}
+	for i := 0; i < 2; i++ {
+		d.Detect(&Commit{Change: &Change{}, Files: []string{orderModel}})
+		d.Detect(&Commit{Change: &Change{}, Files: []string{invoiceView}})
+		d.Detect(bulk)
+	}
+	c := &Commit{Change: &Change{}, Files: []string{orderModel, invoiceView}}
+	if parts := d.Detect(c).Parts; len(parts) != 2 {
+		t.Errorf("parts = %v, want the directories unrelated by the bulk commits", parts)
+	}
+}
+
+func TestAnalyzerTangledPolicies(t *testing.T) {
+	tests := []struct {
+		policy       string
+		combinations map[string]int
+		layers       map[int]int
+	}{
+		{KeepTangled, map[string]int{"mvc": 3, "vc": 3, "v": 1, "mv": 1}, map[int]int{3: 3, 2: 4, 1: 1}},
+		// OFBIZ-3 is split into vc and m
+		{SplitTangled, map[string]int{"mvc": 2, "vc": 4, "m": 1, "v": 1, "mv": 1}, map[int]int{3: 2, 2: 5, 1: 2}},
+		{ExcludeTangled, map[string]int{"mvc": 2, "vc": 3, "mv": 1}, map[int]int{3: 2, 2: 4}},
+	}
+	for _, test := range tests {
+		a := NewAnalyzer(LayerFunc(ofbizLayerExtractor))
+		a.Tangles = newTestTangleDetector()
+		a.TangledPolicy = test.policy
+		tangled := []string{}
+		a.OnTangled = func(c *Commit, _ Tangle) {
+			tangled = append(tangled, c.Change.Comment)
+		}
+		for _, c := range tangleCommits() {
+			a.Add(c)
+		}
+		s := a.Stats()
+		if !reflect.DeepEqual(s.CommitsPerLayerCombination, test.combinations) {
+			t.Errorf("%v: commits per layer combination = %v, want %v", test.policy,
+				s.CommitsPerLayerCombination, test.combinations)
+		}
+		if !reflect.DeepEqual(s.LayersPerCommit, test.layers) {
+			t.Errorf("%v: layers per commit = %v, want %v", test.policy, s.LayersPerCommit, test.layers)
+		}
+		if s.TangledCommits != 2 || !reflect.DeepEqual(s.PartsPerTangledCommit, map[int]int{2: 1, 1: 1}) ||
+			!reflect.DeepEqual(s.KeysPerTangledCommit, map[int]int{1: 1, 2: 1}) ||
+			!reflect.DeepEqual(s.TangledPerLayerCombination, map[string]int{"mvc": 1, "v": 1}) {
+			t.Errorf("%v: %v tangled commits, parts %v, keys %v, combinations %v", test.policy,
+				s.TangledCommits, s.PartsPerTangledCommit, s.KeysPerTangledCommit, s.TangledPerLayerCombination)
+		}
+		want := []string{"OFBIZ-3 order and invoice", "OFBIZ-1 OFBIZ-2 order for OFBIZ-1"}
+		if !reflect.DeepEqual(tangled, want) {
+			t.Errorf("%v: tangled commits %q, want %q", test.policy, tangled, want)
+		}
+	}
+}
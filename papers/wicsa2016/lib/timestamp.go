@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DateOrder int
+
+const (
+	// UnknownOrder rejects numeric dates whose day and month can be swapped.
+	UnknownOrder DateOrder = iota
+	DayFirst
+	MonthFirst
+)
+
+// TimestampParser parses the timestamps found in RTC and git exports: RTC's
+// "03-fev-2012 02:05 PM" in any of the supported languages, numeric dates
+// such as "03/02/2012 14:05", ISO-8601 and epoch milliseconds. Location is
+// the zone of timestamps without one (UTC if nil).
+type TimestampParser struct {
+	Location *time.Location
+	Order    DateOrder
+}
+
+func ParseTimestamp(s string) (time.Time, error) {
+	return TimestampParser{}.Parse(s)
+}
+
+var months = map[string]time.Month{}
+
+func init() {
+	names := [][]string{
+		// en, pt, es, fr, de, it
+		{"jan", "january", "janeiro", "ene", "enero", "janv", "janvier", "januar", "gen", "gennaio"},
+		{"feb", "february", "fev", "fevereiro", "febrero", "fevr", "fevrier", "februar", "febbraio"},
+		{"mar", "march", "marco", "marzo", "mars", "mar", "marz", "mrz"},
+		{"apr", "april", "abr", "abril", "avr", "avril", "aprile"},
+		{"may", "mai", "maio", "mayo", "maggio", "mag"},
+		{"jun", "june", "junho", "junio", "juin", "juni", "giu", "giugno"},
+		{"jul", "july", "julho", "julio", "juil", "juillet", "juli", "lug", "luglio"},
+		{"aug", "august", "ago", "agosto", "aout"},
+		{"sep", "sept", "september", "set", "setembro", "septiembre", "septembre", "settembre"},
+		{"oct", "october", "out", "outubro", "octubre", "octobre", "okt", "oktober", "ott", "ottobre"},
+		{"nov", "november", "novembro", "noviembre", "novembre"},
+		{"dec", "december", "dez", "dezembro", "dic", "diciembre", "decembre", "dezember", "dicembre"},
+	}
+	for i, arr := range names {
+		for _, name := range arr {
+			months[name] = time.Month(i + 1)
+		}
+	}
+}
+
+var accents = strings.NewReplacer("á", "a", "â", "a", "ã", "a", "ç", "c", "é", "e", "ê", "e",
+	"è", "e", "í", "i", "ó", "o", "ô", "o", "ú", "u", "û", "u", "ä", "a", "ö", "o", "ü", "u")
+
+// ParseMonth returns the month named or abbreviated by name in one of the
+// supported languages.
+func ParseMonth(name string) (time.Month, bool) {
+	m, ok := months[accents.Replace(strings.TrimSuffix(strings.ToLower(name), "."))]
+	return m, ok
+}
+
+const clock = `(\d{1,2}):(\d{2})(?::(\d{2})(?:[.,](\d{1,9}))?)?\s*([ap]\.?\s?m\.?)?\s*(Z|UTC|GMT|[+-]\d{2}:?\d{2}|[A-Za-z]{3,5})?`
+
+var (
+	epochMillis = regexp.MustCompile(`^\d{11,13}$`)
+	dayMonth    = regexp.MustCompile(`(?i)^(\d{1,2})[-/ .](?:de )?([\pL.]{3,})[-/ .,]+(?:de )?(\d{4})(?:,?\s+` + clock + `)?$`)
+	monthDay    = regexp.MustCompile(`(?i)^([\pL.]{3,})[-/ ](\d{1,2}),?\s+(\d{4})(?:,?\s+` + clock + `)?$`)
+	numeric     = regexp.MustCompile(`(?i)^(\d{1,2})[-/.](\d{1,2})[-/.](\d{4})(?:,?\s+` + clock + `)?$`)
+)
+
+var isoLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999-0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func (p TimestampParser) Parse(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	loc := p.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	if epochMillis.MatchString(s) {
+		ms, _ := strconv.ParseInt(s, 10, 64)
+		return time.Unix(0, ms*int64(time.Millisecond)).In(loc), nil
+	}
+	if len(s) >= 10 && s[4] == '-' && s[7] == '-' {
+		for _, layout := range isoLayouts {
+			if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid ISO-8601 timestamp %q", s)
+	}
+	var day, month, year string
+	var arr []string
+	if arr = dayMonth.FindStringSubmatch(s); arr != nil {
+		day, month, year = arr[1], arr[2], arr[3]
+	} else if arr = monthDay.FindStringSubmatch(s); arr != nil {
+		day, month, year = arr[2], arr[1], arr[3]
+	} else if arr = numeric.FindStringSubmatch(s); arr != nil {
+		var err error
+		if day, month, err = p.dayAndMonth(s, arr[1], arr[2]); err != nil {
+			return time.Time{}, err
+		}
+		year = arr[3]
+	} else {
+		return time.Time{}, fmt.Errorf("unrecognized timestamp %q", s)
+	}
+	m, err := strconv.Atoi(month)
+	if err != nil {
+		mm, ok := ParseMonth(month)
+		if !ok {
+			return time.Time{}, fmt.Errorf("unknown month %q in timestamp %q", month, s)
+		}
+		m = int(mm)
+	}
+	d, _ := strconv.Atoi(day)
+	y, _ := strconv.Atoi(year)
+	hour, min, sec, nsec, err := parseClock(s, arr[4:])
+	if err != nil {
+		return time.Time{}, err
+	}
+	if zone := arr[len(arr)-1]; zone != "" {
+		if loc, err = parseZone(zone); err != nil {
+			return time.Time{}, fmt.Errorf("%v in timestamp %q", err, s)
+		}
+	}
+	t := time.Date(y, time.Month(m), d, hour, min, sec, nsec, loc)
+	if t.Day() != d || int(t.Month()) != m {
+		return time.Time{}, fmt.Errorf("invalid date in timestamp %q", s)
+	}
+	return t, nil
+}
+
+func (p TimestampParser) dayAndMonth(s, first, second string) (day, month string, err error) {
+	a, _ := strconv.Atoi(first)
+	b, _ := strconv.Atoi(second)
+	switch {
+	case a > 12 && b > 12:
+		return "", "", fmt.Errorf("invalid date in timestamp %q", s)
+	case a > 12:
+		return first, second, nil
+	case b > 12:
+		return second, first, nil
+	case a == b || p.Order == DayFirst:
+		return first, second, nil
+	case p.Order == MonthFirst:
+		return second, first, nil
+	default:
+		return "", "", fmt.Errorf("ambiguous date in timestamp %q: day and month "+
+			"can be swapped, specify the date order", s)
+	}
+}
+
+// parseClock parses the hour, minutes, seconds, fraction and AM/PM groups.
+func parseClock(s string, arr []string) (hour, min, sec, nsec int, err error) {
+	if arr[0] == "" {
+		return 0, 0, 0, 0, nil
+	}
+	hour, _ = strconv.Atoi(arr[0])
+	min, _ = strconv.Atoi(arr[1])
+	if arr[2] != "" {
+		sec, _ = strconv.Atoi(arr[2])
+	}
+	if arr[3] != "" {
+		nsec, _ = strconv.Atoi((arr[3] + "000000000")[:9])
+	}
+	if ampm := strings.ToLower(arr[4]); ampm != "" {
+		if hour < 1 || hour > 12 {
+			return 0, 0, 0, 0, fmt.Errorf("invalid 12-hour clock in timestamp %q", s)
+		}
+		hour %= 12
+		if ampm[0] == 'p' {
+			hour += 12
+		}
+	}
+	if hour > 23 || min > 59 || sec > 60 {
+		return 0, 0, 0, 0, fmt.Errorf("invalid time in timestamp %q", s)
+	}
+	return hour, min, sec, nsec, nil
+}
+
+func parseZone(zone string) (*time.Location, error) {
+	switch strings.ToUpper(zone) {
+	case "Z", "UTC", "GMT":
+		return time.UTC, nil
+	}
+	if zone[0] != '+' && zone[0] != '-' {
+		return nil, fmt.Errorf("ambiguous time zone abbreviation %q", zone)
+	}
+	digits := strings.Replace(zone[1:], ":", "", 1)
+	h, _ := strconv.Atoi(digits[:2])
+	m, _ := strconv.Atoi(digits[2:])
+	offset := h*3600 + m*60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone(zone, offset), nil
+}
@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestampParser(t *testing.T) {
+	brasilia := time.FixedZone("BRT", -3*3600)
+	tests := []struct {
+		s     string
+		order DateOrder
+		want  time.Time
+		err   string
+	}{
+		// RTC in pt-BR, en-US and es.
+		{s: "03-fev-2012 02:05 PM", want: time.Date(2012, 2, 3, 14, 5, 0, 0, brasilia)},
+		{s: "15-dez-2011 09:41 AM", want: time.Date(2011, 12, 15, 9, 41, 0, 0, brasilia)},
+		{s: "3 de março de 2012 14:05", want: time.Date(2012, 3, 3, 14, 5, 0, 0, brasilia)},
+		{s: "Feb 3, 2012 2:05:17 PM", want: time.Date(2012, 2, 3, 14, 5, 17, 0, brasilia)},
+		{s: "03-Sep-2012 11:30 pm", want: time.Date(2012, 9, 3, 23, 30, 0, 0, brasilia)},
+		{s: "03-ene-2012 02:05 p. m.", want: time.Date(2012, 1, 3, 14, 5, 0, 0, brasilia)},
+		{s: "03-dic.-2012 02:05 a. m.", want: time.Date(2012, 12, 3, 2, 5, 0, 0, brasilia)},
+		// 12 AM is midnight and 12 PM noon.
+		{s: "03-fev-2012 12:05 AM", want: time.Date(2012, 2, 3, 0, 5, 0, 0, brasilia)},
+		{s: "03-fev-2012 12:05 PM", want: time.Date(2012, 2, 3, 12, 5, 0, 0, brasilia)},
+		{s: "03-fev-2012 13:05 PM", err: "invalid 12-hour clock"},
+		{s: "03-fev-2012 00:05 AM", err: "invalid 12-hour clock"},
+		// Seconds and zones are kept.
+		{s: "03-fev-2012 14:05:22 UTC", want: time.Date(2012, 2, 3, 14, 5, 22, 0, time.UTC)},
+		{s: "03-fev-2012 14:05 -0200", want: time.Date(2012, 2, 3, 16, 5, 0, 0, time.UTC)},
+		// ISO-8601 with and without a zone, and epoch milliseconds.
+		{s: "2012-02-03T14:05:22Z", want: time.Date(2012, 2, 3, 14, 5, 22, 0, time.UTC)},
+		{s: "2012-02-03T14:05:22.250-02:00", want: time.Date(2012, 2, 3, 16, 5, 22, 250e6, time.UTC)},
+		{s: "2012-02-03 14:05:22 -0200", want: time.Date(2012, 2, 3, 16, 5, 22, 0, time.UTC)},
+		{s: "2012-02-03T14:05:22", want: time.Date(2012, 2, 3, 14, 5, 22, 0, brasilia)},
+		{s: "2012-02-03", want: time.Date(2012, 2, 3, 0, 0, 0, 0, brasilia)},
+		{s: "1328285122000", want: time.Date(2012, 2, 3, 16, 5, 22, 0, time.UTC)},
+		// Numeric dates are read only when the day and month are clear.
+		{s: "03/02/2012 14:05", err: "ambiguous date"},
+		{s: "03/02/2012 14:05", order: DayFirst, want: time.Date(2012, 2, 3, 14, 5, 0, 0, brasilia)},
+		{s: "03/02/2012 14:05", order: MonthFirst, want: time.Date(2012, 3, 2, 14, 5, 0, 0, brasilia)},
+		{s: "15/02/2012 14:05", want: time.Date(2012, 2, 15, 14, 5, 0, 0, brasilia)},
+		{s: "02/15/2012", want: time.Date(2012, 2, 15, 0, 0, 0, 0, brasilia)},
+		{s: "03/03/2012", want: time.Date(2012, 3, 3, 0, 0, 0, 0, brasilia)},
+		// Invalid dates and zones.
+		{s: "31-abr-2012 10:00", err: "invalid date"},
+		{s: "29/02/2011", order: DayFirst, err: "invalid date"},
+		{s: "15/13/2012", err: "invalid date"},
+		{s: "2012-02-30", err: "invalid ISO-8601 timestamp"},
+		{s: "03-foo-2012", err: "unknown month"},
+		{s: "03-fev-2012 25:00", err: "invalid time"},
+		{s: "03-fev-2012 14:05 BRT", err: "ambiguous time zone abbreviation"},
+		{s: "03-fev-2012 14:05 EST", err: "ambiguous time zone abbreviation"},
+		{s: "yesterday", err: "unrecognized timestamp"},
+	}
+	for _, test := range tests {
+		got, err := TimestampParser{Location: brasilia, Order: test.order}.Parse(test.s)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Parse(%q) = %v, %v, want error %q", test.s, got, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(test.want) {
+			t.Errorf("Parse(%q) = %v, %v, want %v", test.s, got, err, test.want)
+		}
+	}
+}
+
+func TestParseTimestampDefaultsToUTC(t *testing.T) {
+	got, err := ParseTimestamp("2012-02-03 14:05")
+	if err != nil || got.Location() != time.UTC || got.Hour() != 14 {
+		t.Errorf("ParseTimestamp = %v, %v, want 14:05 UTC", got, err)
+	}
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Definitions of the users of a change: its author, its committer, its
+// author and co-authors, or all of them.
+const (
+	AuthorUsers    = "author"
+	CommitterUsers = "committer"
+	CoAuthorUsers  = "coauthors"
+	AllUsers       = "all"
+)
+
+func CheckUsersDefinition(definition string) error {
+	switch definition {
+	case AuthorUsers, CommitterUsers, CoAuthorUsers, AllUsers:
+		return nil
+	}
+	return fmt.Errorf("unknown users definition %q (choose among author, committer, coauthors, all)",
+		definition)
+}
+
+// Users returns the users of c under definition, the committer being the
+// author when the source does not tell them apart.
+func (c *Change) Users(definition string) []string {
+	committer := c.Committer
+	if committer == "" {
+		committer = c.Author
+	}
+	switch definition {
+	case CommitterUsers:
+		return []string{committer}
+	case CoAuthorUsers:
+		return distinct(append([]string{c.Author}, c.CoAuthors...))
+	case AllUsers:
+		return distinct(append([]string{c.Author, committer}, c.CoAuthors...))
+	}
+	return []string{c.Author}
+}
+
+var coAuthorRegex = regexp.MustCompile(`(?im)^co-authored-by:\s*(.+)$`)
+
+// CoAuthors returns the names of the Co-authored-by trailers of message.
+func CoAuthors(message string) []string {
+	names := []string{}
+	for _, arr := range coAuthorRegex.FindAllStringSubmatch(message, -1) {
+		names = append(names, trailerName(arr[1]))
+	}
+	return distinct(names)
+}
+
+// trailerName strips the e-mail of "Name <e-mail>", as git names authors
+// without it.
+func trailerName(value string) string {
+	if i := strings.Index(value, "<"); i > 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
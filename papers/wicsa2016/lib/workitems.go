@@ -0,0 +1,462 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WorkItem is an issue of Jira or a work item of RTC. Parent is the item it
+// belongs to: the parent of a sub-task, the epic of a story, the feature of
+// an RTC story. OriginalKind is the kind given by the tracker, which differs
+// from Kind for sub-tasks as they take the kind of their parents.
+type WorkItem struct {
+	Id           string
+	Kind         string
+	OriginalKind string
+	Title        string
+	Description  string
+	Parent       string
+	Links        []Link
+	Components   []string
+	Labels       []string
+}
+
+// Link relates two items, e.g. {"blocks", "OFBIZ-2"}. Kind is one of blocks,
+// blocked-by, relates, duplicates, duplicated-by or the tracker's own name.
+type Link struct {
+	Kind   string
+	Target string
+}
+
+type WorkItemGraph struct {
+	items    map[string]*WorkItem
+	children map[string][]string
+}
+
+func NewWorkItemGraph() *WorkItemGraph {
+	return &WorkItemGraph{items: map[string]*WorkItem{}, children: map[string][]string{}}
+}
+
+// Add adds item, replacing the one with the same id.
+func (g *WorkItemGraph) Add(item *WorkItem) {
+	if old, ok := g.items[item.Id]; ok && old.Parent != "" {
+		g.removeChild(old.Parent, item.Id)
+	}
+	g.items[item.Id] = item
+	if item.Parent != "" {
+		g.children[item.Parent] = append(g.children[item.Parent], item.Id)
+	}
+}
+
+func (g *WorkItemGraph) removeChild(parent, id string) {
+	children := g.children[parent]
+	for i, c := range children {
+		if c == id {
+			g.children[parent] = append(children[:i:i], children[i+1:]...)
+			return
+		}
+	}
+}
+
+func (g *WorkItemGraph) Item(id string) *WorkItem {
+	return g.items[id]
+}
+
+func (g *WorkItemGraph) Len() int {
+	return len(g.items)
+}
+
+// Ids returns the ids of the items in lexical order.
+func (g *WorkItemGraph) Ids() []string {
+	ids := make([]string, 0, len(g.items))
+	for id := range g.items {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (g *WorkItemGraph) Children(id string) []*WorkItem {
+	children := make([]*WorkItem, 0, len(g.children[id]))
+	for _, c := range g.children[id] {
+		children = append(children, g.items[c])
+	}
+	return children
+}
+
+// Ancestors returns the parent of the item, its parent and so on, stopping
+// at items missing from the graph and at cycles.
+func (g *WorkItemGraph) Ancestors(id string) []*WorkItem {
+	ancestors := []*WorkItem{}
+	seen := map[string]bool{id: true}
+	item := g.items[id]
+	for item != nil && item.Parent != "" && !seen[item.Parent] {
+		seen[item.Parent] = true
+		item = g.items[item.Parent]
+		if item != nil {
+			ancestors = append(ancestors, item)
+		}
+	}
+	return ancestors
+}
+
+// Ancestor returns the item or its closest ancestor of the kind, compared
+// ignoring case, or nil if there is none.
+func (g *WorkItemGraph) Ancestor(id, kind string) *WorkItem {
+	if item := g.items[id]; item != nil && strings.EqualFold(item.Kind, kind) {
+		return item
+	}
+	for _, a := range g.Ancestors(id) {
+		if strings.EqualFold(a.Kind, kind) {
+			return a
+		}
+	}
+	return nil
+}
+
+// RollUp returns the id of the ancestor of the kind of the item, or "".
+func (g *WorkItemGraph) RollUp(id, kind string) string {
+	if a := g.Ancestor(id, kind); a != nil {
+		return a.Id
+	}
+	return ""
+}
+
+// Linked returns the items the item links to with the kind of link.
+func (g *WorkItemGraph) Linked(id, kind string) []*WorkItem {
+	linked := []*WorkItem{}
+	if item := g.items[id]; item != nil {
+		for _, l := range item.Links {
+			if l.Kind == kind && g.items[l.Target] != nil {
+				linked = append(linked, g.items[l.Target])
+			}
+		}
+	}
+	return linked
+}
+
+// FeatureRule tells how to derive the feature of a Jira issue: the first of
+// its sources giving a non empty feature wins.
+type FeatureRule []string
+
+var featureSources = map[string]func(*WorkItemGraph, string) string{
+	"epic": func(g *WorkItemGraph, id string) string {
+		return g.RollUp(id, "Epic")
+	},
+	// parent is the root of the hierarchy of the issue, e.g. the parent of a
+	// sub-task or the epic of a story, none for the issues without parent.
+	"parent": func(g *WorkItemGraph, id string) string {
+		if ancestors := g.Ancestors(id); len(ancestors) > 0 {
+			return ancestors[len(ancestors)-1].Id
+		}
+		return ""
+	},
+	"component": func(g *WorkItemGraph, id string) string {
+		return g.inherited(id, func(item *WorkItem) []string { return item.Components })
+	},
+	"label": func(g *WorkItemGraph, id string) string {
+		return g.inherited(id, func(item *WorkItem) []string { return item.Labels })
+	},
+}
+
+// ParseFeatureRule parses a comma separated list of epic, parent, component
+// and label.
+func ParseFeatureRule(s string) (FeatureRule, error) {
+	rule := FeatureRule{}
+	for _, source := range strings.Split(s, ",") {
+		source = strings.ToLower(strings.TrimSpace(source))
+		if source == "" {
+			continue
+		}
+		if featureSources[source] == nil {
+			return nil, fmt.Errorf("unknown feature source %q (choose among epic, parent, component, label)", source)
+		}
+		rule = append(rule, source)
+	}
+	return rule, nil
+}
+
+// Feature returns the feature of the item id according to the rule.
+func (r FeatureRule) Feature(g *WorkItemGraph, id string) string {
+	for _, source := range r {
+		if f := featureSources[source](g, id); f != "" {
+			return f
+		}
+	}
+	return ""
+}
+
+// inherited returns the first value of the item or, when it has none, of
+// its closest ancestor having one, in lexical order for stable results.
+func (g *WorkItemGraph) inherited(id string, values func(*WorkItem) []string) string {
+	items := append([]*WorkItem{g.items[id]}, g.Ancestors(id)...)
+	for _, item := range items {
+		if item == nil || len(values(item)) == 0 {
+			continue
+		}
+		v := append([]string{}, values(item)...)
+		sort.Strings(v)
+		return v[0]
+	}
+	return ""
+}
+
+// NormalizeLinkKind maps the descriptions of Jira and RTC links to the kinds
+// of Link.
+func NormalizeLinkKind(description string) string {
+	d := strings.ToLower(strings.TrimSpace(description))
+	switch d {
+	case "blocks":
+		return "blocks"
+	case "is blocked by", "blocked by":
+		return "blocked-by"
+	case "relates to", "related to", "related", "relates":
+		return "relates"
+	case "duplicates":
+		return "duplicates"
+	case "is duplicated by", "duplicated by":
+		return "duplicated-by"
+	}
+	return d
+}
+
+func formatLinks(links []Link) string {
+	arr := make([]string, 0, len(links))
+	for _, l := range links {
+		arr = append(arr, l.Kind+":"+l.Target)
+	}
+	return strings.Join(arr, " ")
+}
+
+func parseLinks(s string) []Link {
+	links := []Link{}
+	for _, f := range strings.Fields(s) {
+		if i := strings.LastIndex(f, ":"); i > 0 {
+			links = append(links, Link{Kind: f[:i], Target: f[i+1:]})
+		}
+	}
+	return links
+}
+
+// JiraIssuesSchema describes the issues file written by the fetcher. Files
+// written by earlier versions have no header and only the key and type.
+var JiraIssuesSchema = Schema{Name: "issues", Columns: []Column{
+	{Name: "Key", Required: true},
+	{Name: "Type", Required: true},
+	{Name: "Title"},
+	{Name: "Parent"},
+	{Name: "Epic"},
+	{Name: "Links"},
+	{Name: "Components"},
+	{Name: "Labels"},
+	{Name: "Original Type"},
+	{Name: "Description"}}}
+
+// FormatJiraIssue returns the issues file record of item. Components and
+// labels are separated by ";" as Jira allows spaces in them.
+func FormatJiraIssue(item *WorkItem, epic string) []string {
+	return []string{item.Id, item.Kind, item.Title, item.Parent, epic, formatLinks(item.Links),
+		strings.Join(item.Components, ";"), strings.Join(item.Labels, ";"), item.OriginalKind,
+		item.Description}
+}
+
+// ParseJiraIssue returns the item and the epic of a record written by
+// FormatJiraIssue.
+func ParseJiraIssue(row *Row) (*WorkItem, string) {
+	return &WorkItem{Id: row.Get("Key"), Kind: row.Get("Type"), Title: row.Get("Title"),
+		Parent: row.Get("Parent"), Links: parseLinks(row.Get("Links")),
+		Components: splitList(row.Get("Components")), Labels: splitList(row.Get("Labels")),
+		OriginalKind: row.Get("Original Type"), Description: row.Get("Description")}, row.Get("Epic")
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func JiraIssuesHeader() []string {
+	header := make([]string, len(JiraIssuesSchema.Columns))
+	for i, c := range JiraIssuesSchema.Columns {
+		header[i] = c.Name
+	}
+	return header
+}
+
+// LoadJiraWorkItems reads the issues file written by the fetcher. Stories
+// and tasks are children of their epics, sub-tasks of their parents.
+func LoadJiraWorkItems(file string) (*WorkItemGraph, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening issues file: %v", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	first, _ := r.Peek(4)
+	g := NewWorkItemGraph()
+	if !strings.EqualFold(string(first), "key,") {
+		return g, readLegacyIssues(file, r, g)
+	}
+	t, err := NewTableReader(r, JiraIssuesSchema)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	for {
+		row, err := t.Read()
+		if err == io.EOF {
+			return g, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+		item, epic := ParseJiraIssue(row)
+		if item.Parent == "" {
+			item.Parent = epic
+		}
+		g.Add(item)
+	}
+}
+
+func readLegacyIssues(file string, r io.Reader, g *WorkItemGraph) error {
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		arr := strings.SplitN(scan.Text(), ",", 2)
+		if len(arr) < 2 {
+			return fmt.Errorf("error reading issues file %v: malformed line %q", file, scan.Text())
+		}
+		g.Add(&WorkItem{Id: arr[0], Kind: arr[1]})
+	}
+	if err := scan.Err(); err != nil {
+		return fmt.Errorf("error reading issues file %v: %v", file, err)
+	}
+	return nil
+}
+
+// The work item exports consolidate links to change sets, with the column
+// names of the English and Portuguese exports.
+var (
+	DefectsSchema = Schema{Name: "defects.csv", Columns: []Column{
+		{Name: "Id", Aliases: []string{"ID", "Identificador"}, Required: true},
+		{Name: "Filed Against", Aliases: []string{"Arquivado Contra", "Categoria"}, Required: true},
+		{Name: "Change Sets", Aliases: []string{"Conjuntos de Mudanças", "Conjuntos de Alterações"}, Required: true}}}
+	StoriesSchema = Schema{Name: "stories.csv", Columns: []Column{
+		{Name: "Id", Aliases: []string{"ID", "Identificador"}},
+		{Name: "Story", Aliases: []string{"Parent", "Pai", "História"}, Required: true},
+		{Name: "Change Sets", Aliases: []string{"Conjuntos de Mudanças", "Conjuntos de Alterações"}, Required: true}}}
+	FeaturesSchema = Schema{Name: "features.csv", Columns: []Column{
+		{Name: "Id", Aliases: []string{"ID", "Identificador"}, Required: true},
+		{Name: "Feature", Aliases: []string{"Parent", "Pai", "Funcionalidade"}, Required: true}}}
+	IssuesSchema = Schema{Name: "siop-issues.csv", Columns: []Column{
+		{Name: "Id", Aliases: []string{"ID", "Identificador"}, Required: true},
+		{Name: "Type", Aliases: []string{"Tipo"}, Required: true}}}
+)
+
+// EpicsSchema describes the optional export of the features of each epic.
+var EpicsSchema = Schema{Name: "epics.csv", Columns: []Column{
+	{Name: "Id", Aliases: []string{"ID", "Identificador"}, Required: true},
+	{Name: "Epic", Aliases: []string{"Parent", "Pai", "Épico"}, Required: true}}}
+
+// LoadRTCWorkItems reads the work item exports of dir: the tasks of the
+// stories (stories.csv), the features of the stories (features.csv), the
+// epics of the features (epics.csv, optional) and the defects.
+func LoadRTCWorkItems(dir string, aliases map[string][]string) (*WorkItemGraph, error) {
+	g := NewWorkItemGraph()
+	add := func(id, kind, parent string) {
+		if id == "" {
+			return
+		}
+		if item := g.Item(id); item != nil && item.Parent != "" && parent == "" {
+			return
+		}
+		g.Add(&WorkItem{Id: id, Kind: kind, Parent: parent})
+	}
+	ensure := func(id, kind string) {
+		if id != "" && g.Item(id) == nil {
+			g.Add(&WorkItem{Id: id, Kind: kind})
+		}
+	}
+	tables := []struct {
+		schema   Schema
+		optional bool
+		row      func(*Row) error
+	}{
+		{EpicsSchema, true, func(row *Row) error {
+			epic := rtcReference(row.Get("Epic"))
+			add(row.Get("Id"), "feature", epic)
+			ensure(epic, "epic")
+			return nil
+		}},
+		{FeaturesSchema, false, func(row *Row) error {
+			feature := rtcReference(row.Get("Feature"))
+			add(row.Get("Id"), "story", feature)
+			ensure(feature, "feature")
+			return nil
+		}},
+		{StoriesSchema, false, func(row *Row) error {
+			story := rtcReference(row.Get("Story"))
+			add(row.Get("Id"), "task", story)
+			ensure(story, "story")
+			return nil
+		}},
+		{DefectsSchema, false, func(row *Row) error {
+			add(row.Get("Id"), "bug", "")
+			return nil
+		}},
+	}
+	for _, t := range tables {
+		err := EachExportRow(dir, t.schema.WithAliases(aliases), t.row)
+		if os.IsNotExist(err) && t.optional {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// rtcReference returns the id of references such as "#20" or "20: Reports".
+func rtcReference(s string) string {
+	return strings.TrimPrefix(strings.TrimSpace(strings.Split(s, ":")[0]), "#")
+}
+
+// EachTableRow calls f for each row of the table of r described by schema,
+// reporting on stderr the malformed rows and the errors f returns, which are
+// those of rows (see Row.Error).
+func EachTableRow(r io.Reader, schema Schema, f func(*Row) error) error {
+	t, err := NewTableReader(r, schema)
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := t.Read()
+		if err == io.EOF {
+			return nil
+		} else if _, ok := err.(*RowError); ok {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := f(row); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// EachExportRow calls EachTableRow for the export of dir named after schema.
+func EachExportRow(dir string, schema Schema, f func(*Row) error) error {
+	file, err := os.Open(filepath.Join(dir, schema.Name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return EachTableRow(file, schema, f)
+}
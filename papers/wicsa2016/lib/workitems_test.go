@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The exports of testdata/exports have the headers of the English and
+// Portuguese work item exports, the English defects starting with a BOM.
+func TestExportHeaders(t *testing.T) {
+	for _, lang := range []string{"en", "pt"} {
+		dir := filepath.Join("testdata", "exports", lang)
+		got := map[string][]string{}
+		for _, schema := range []Schema{DefectsSchema, StoriesSchema, FeaturesSchema, EpicsSchema,
+			IssuesSchema} {
+			err := EachExportRow(dir, schema, func(row *Row) error {
+				for _, c := range schema.Columns {
+					got[schema.Name] = append(got[schema.Name], row.Get(c.Name))
+				}
+				return nil
+			})
+			if err != nil {
+				t.Errorf("%v: %v", lang, err)
+			}
+		}
+		changeSet := map[string]string{"en": "Changes - ", "pt": "Alterações - "}[lang]
+		if d := got["defects.csv"]; len(d) != 3 || d[0] != "30" ||
+			!strings.HasPrefix(d[1], "Execu") || !strings.HasPrefix(d[2], changeSet+"30: ") {
+			t.Errorf("%v: defects row = %q", lang, d)
+		}
+		if s := got["stories.csv"]; len(s) != 3 || s[0] != "40" || s[1] != "#20" ||
+			!strings.HasPrefix(s[2], changeSet+"20: ") {
+			t.Errorf("%v: stories row = %q", lang, s)
+		}
+		if i := got["siop-issues.csv"]; !reflect.DeepEqual(i, []string{"20", "2", "30", "1"}) {
+			t.Errorf("%v: issues rows = %q", lang, i)
+		}
+		g, err := LoadRTCWorkItems(dir, nil)
+		if err != nil {
+			t.Fatalf("%v: %v", lang, err)
+		}
+		if f, e := g.RollUp("40", "feature"), g.RollUp("40", "epic"); f != "10" || e != "5" {
+			t.Errorf("%v: task 40 rolls up to feature %q and epic %q, want 10 and 5", lang, f, e)
+		}
+	}
+}
+
+func TestExportMissingColumn(t *testing.T) {
+	schema := DefectsSchema
+	schema.Name = "features.csv"
+	err := EachExportRow(filepath.Join("testdata", "exports", "en"), schema,
+		func(*Row) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "missing columns Filed Against, Change Sets") {
+		t.Errorf("err = %v, want the missing columns", err)
+	}
+}
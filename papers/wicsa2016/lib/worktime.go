@@ -0,0 +1,216 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Calendar tells the working time: the hours from Start to End of the
+// working days, less the holidays, in Location, or in the zone each
+// timestamp was recorded in if nil.
+type Calendar struct {
+	Location    *time.Location
+	Start       int
+	End         int
+	WorkingDays [7]bool
+	Holidays    map[string]bool
+}
+
+var DefaultCalendar = Calendar{Start: 9, End: 18,
+	WorkingDays: [7]bool{time.Monday: true, time.Tuesday: true, time.Wednesday: true,
+		time.Thursday: true, time.Friday: true}}
+
+var weekdays = map[string]time.Weekday{"sun": time.Sunday, "mon": time.Monday,
+	"tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday,
+	"sat": time.Saturday}
+
+// ParseWeekdays parses lists of days and ranges of days such as "Mon-Fri" or
+// "Sun-Thu,Sat".
+func ParseWeekdays(s string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return days, fmt.Errorf("unknown weekday %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return days, fmt.Errorf("unknown weekday %q", bounds[1])
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// ParseHours parses ranges of hours such as "9-18".
+func ParseHours(s string) (start, end int, err error) {
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("bad working hours %q (e.g. 9-18)", s)
+	}
+	if start, err = strconv.Atoi(strings.TrimSpace(bounds[0])); err == nil {
+		end, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+	}
+	if err != nil || start < 0 || end > 24 || start >= end {
+		return 0, 0, fmt.Errorf("bad working hours %q (e.g. 9-18)", s)
+	}
+	return start, end, nil
+}
+
+// LoadHolidays reads a file of dates, one "2006-01-02" per line, where blank
+// lines and lines starting with # are ignored.
+func LoadHolidays(file string) (map[string]bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	holidays := map[string]bool{}
+	scan := bufio.NewScanner(f)
+	for line := 1; scan.Scan(); line++ {
+		text := strings.TrimSpace(scan.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		date := strings.Fields(text)[0]
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return nil, fmt.Errorf("%v:%v: bad date %q", file, line, date)
+		}
+		holidays[date] = true
+	}
+	return holidays, scan.Err()
+}
+
+func (c Calendar) in(t time.Time) time.Time {
+	if c.Location == nil {
+		return t
+	}
+	return t.In(c.Location)
+}
+
+func (c Calendar) workingDay(day time.Time) bool {
+	return c.WorkingDays[day.Weekday()] && !c.Holidays[day.Format("2006-01-02")]
+}
+
+// WorkingHours returns the working hours between from and to.
+func (c Calendar) WorkingHours(from, to time.Time) float64 {
+	if !to.After(from) {
+		return 0
+	}
+	from = c.in(from)
+	loc := from.Location()
+	to = to.In(loc)
+	hours := 0.0
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !c.workingDay(day) {
+			continue
+		}
+		// by the clock, so that days with a DST transition are not shifted
+		start := time.Date(day.Year(), day.Month(), day.Day(), c.Start, 0, 0, 0, loc)
+		end := time.Date(day.Year(), day.Month(), day.Day(), c.End, 0, 0, 0, loc)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			hours += end.Sub(start).Hours()
+		}
+	}
+	return hours
+}
+
+// Intervals are the hours between consecutive commits, of wall clock time
+// and of working time.
+type Intervals struct {
+	Wall    []float64
+	Working []float64
+}
+
+// IntervalAnalyzer computes the intervals between the consecutive commits of
+// each author and of each issue, and counts the commits by weekday and hour,
+// in the location of Calendar. Commits must be added in time order.
+type IntervalAnalyzer struct {
+	Calendar   Calendar
+	Heatmap    [7][24]int
+	authors    map[string]*Intervals
+	issues     map[string]*Intervals
+	lastAuthor map[string]time.Time
+	lastIssue  map[string]time.Time
+}
+
+func NewIntervalAnalyzer(calendar Calendar) *IntervalAnalyzer {
+	return &IntervalAnalyzer{Calendar: calendar, authors: map[string]*Intervals{},
+		issues: map[string]*Intervals{}, lastAuthor: map[string]time.Time{},
+		lastIssue: map[string]time.Time{}}
+}
+
+func (a *IntervalAnalyzer) Add(c *Commit) {
+	t := c.Change.ModifiedTime
+	local := a.Calendar.in(t)
+	a.Heatmap[local.Weekday()][local.Hour()]++
+	a.interval(a.authors, a.lastAuthor, c.Change.Author, t)
+	if c.Issue.Id != "" {
+		a.interval(a.issues, a.lastIssue, c.Issue.Id, t)
+	}
+}
+
+func (a *IntervalAnalyzer) interval(intervals map[string]*Intervals, last map[string]time.Time,
+	key string, t time.Time) {
+	if intervals[key] == nil {
+		intervals[key] = &Intervals{}
+	}
+	if previous, ok := last[key]; ok {
+		i := intervals[key]
+		i.Wall = append(i.Wall, t.Sub(previous).Hours())
+		i.Working = append(i.Working, a.Calendar.WorkingHours(previous, t))
+	}
+	last[key] = t
+}
+
+func (a *IntervalAnalyzer) Authors() map[string]*Intervals {
+	return a.authors
+}
+
+func (a *IntervalAnalyzer) Issues() map[string]*Intervals {
+	return a.issues
+}
+
+// Summary describes a sample of values.
+type Summary struct {
+	N      int
+	Mean   float64
+	Median float64
+	P90    float64
+}
+
+func Summarize(values []float64) Summary {
+	s := Summary{N: len(values), Mean: math.NaN(), Median: Median(values), P90: math.NaN()}
+	if len(values) == 0 {
+		return s
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	s.Mean = sum / float64(len(values))
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	s.P90 = sorted[int(math.Ceil(0.9*float64(len(sorted))))-1]
+	return s
+}
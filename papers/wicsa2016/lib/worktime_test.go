@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWeekdays(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []time.Weekday
+		err  string
+	}{
+		{s: "Mon-Fri", want: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}},
+		{s: "Sun-Thu,Sat", want: []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday,
+			time.Thursday, time.Saturday}},
+		{s: "fri-mon", want: []time.Weekday{time.Sunday, time.Monday, time.Friday, time.Saturday}},
+		{s: "wed", want: []time.Weekday{time.Wednesday}},
+		{s: "Mon-Fry", err: `unknown weekday "fry"`},
+		{s: "", err: `unknown weekday ""`},
+	}
+	for _, test := range tests {
+		days, err := ParseWeekdays(test.s)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("ParseWeekdays(%q) error = %v, want %v", test.s, err, test.err)
+			}
+			continue
+		}
+		got := []time.Weekday{}
+		for d, working := range days {
+			if working {
+				got = append(got, time.Weekday(d))
+			}
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseWeekdays(%q) = %v, %v, want %v", test.s, got, err, test.want)
+		}
+	}
+}
+
+func TestParseHours(t *testing.T) {
+	if start, end, err := ParseHours("9-18"); start != 9 || end != 18 || err != nil {
+		t.Errorf("ParseHours(9-18) = %v, %v, %v", start, end, err)
+	}
+	if start, end, err := ParseHours(" 0 - 24 "); start != 0 || end != 24 || err != nil {
+		t.Errorf("ParseHours(0-24) = %v, %v, %v", start, end, err)
+	}
+	for _, s := range []string{"9", "18-9", "9-25", "-1-5", "a-b"} {
+		if _, _, err := ParseHours(s); err == nil {
+			t.Errorf("ParseHours(%q) succeeded", s)
+		}
+	}
+}
+
+func TestLoadHolidays(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "holidays.txt")
+	ioutil.WriteFile(file, []byte("# Brazil 2012\n2012-02-20 carnaval\n\n2012-02-21\n"), 0644)
+	holidays, err := LoadHolidays(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"2012-02-20": true, "2012-02-21": true}; !reflect.DeepEqual(holidays, want) {
+		t.Errorf("holidays = %v, want %v", holidays, want)
+	}
+	ioutil.WriteFile(file, []byte("2012-02-20\n20/02/2012\n"), 0644)
+	if _, err := LoadHolidays(file); err == nil || !strings.HasSuffix(err.Error(), `:2: bad date "20/02/2012"`) {
+		t.Errorf("err = %v, want a bad date on line 2", err)
+	}
+}
+
+func TestWorkingHours(t *testing.T) {
+	brasilia := time.FixedZone("BRT", -3*3600)
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, brasilia)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	calendar := DefaultCalendar
+	calendar.Holidays = map[string]bool{"2012-02-21": true}
+	tests := []struct {
+		from, to string
+		want     float64
+	}{
+		{"2012-02-15 10:00", "2012-02-15 12:30", 2.5},
+		{"2012-02-15 07:00", "2012-02-15 20:00", 9},
+		{"2012-02-15 17:00", "2012-02-16 10:00", 2},
+		// Friday evening to Monday morning spans no working time
+		{"2012-02-17 18:30", "2012-02-20 08:00", 0},
+		{"2012-02-17 17:00", "2012-02-20 10:00", 2},
+		// the holiday is skipped
+		{"2012-02-20 17:00", "2012-02-22 10:00", 2},
+		{"2012-02-15 12:00", "2012-02-15 11:00", 0},
+	}
+	for _, test := range tests {
+		if got := calendar.WorkingHours(at(test.from), at(test.to)); got != test.want {
+			t.Errorf("WorkingHours(%v, %v) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+	// in the calendar's location, the 17:00 to 10:00 UTC night is 14:00 to 07:00
+	calendar.Location = brasilia
+	from := time.Date(2012, 2, 15, 17, 0, 0, 0, time.UTC)
+	if got := calendar.WorkingHours(from, from.Add(17*time.Hour)); got != 4 {
+		t.Errorf("WorkingHours in %v = %v, want 4", brasilia, got)
+	}
+}
+
+func TestWorkingHoursAcrossDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	calendar := Calendar{Location: ny, Start: 9, End: 18, WorkingDays: [7]bool{true, true, true, true,
+		true, true, true}}
+	// clocks went forward at 2:00 on 2016-03-13 and back at 2:00 on 2016-11-06
+	for _, day := range []time.Time{time.Date(2016, 3, 13, 0, 0, 0, 0, ny),
+		time.Date(2016, 11, 6, 0, 0, 0, 0, ny)} {
+		if got := calendar.WorkingHours(day, day.AddDate(0, 0, 1)); got != 9 {
+			t.Errorf("WorkingHours on %v = %v, want 9", day.Format("2006-01-02"), got)
+		}
+		from := time.Date(day.Year(), day.Month(), day.Day(), 8, 0, 0, 0, ny)
+		if got := calendar.WorkingHours(from, from.Add(2*time.Hour)); got != 1 {
+			t.Errorf("WorkingHours from 8:00 on %v = %v, want 1", day.Format("2006-01-02"), got)
+		}
+	}
+}
+
+func TestIntervalAnalyzer(t *testing.T) {
+	calendar := DefaultCalendar
+	calendar.Location = time.FixedZone("BRT", -3*3600)
+	a := NewIntervalAnalyzer(calendar)
+	commit := func(author, issue, at string) *Commit {
+		v, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Commit{Issue: Issue{Id: issue}, Change: &Change{Author: author, ModifiedTime: v}}
+	}
+	for _, c := range []*Commit{
+		commit("ana", "OFBIZ-1", "2012-02-15T13:00:00Z"), // Wednesday 10:00
+		commit("rui", "", "2012-02-15T14:00:00Z"),
+		commit("ana", "OFBIZ-2", "2012-02-15T15:30:00Z"),
+		commit("ana", "OFBIZ-1", "2012-02-17T20:00:00Z"), // Friday 17:00
+		commit("rui", "OFBIZ-1", "2012-02-20T13:00:00Z"), // Monday 10:00
+	} {
+		a.Add(c)
+	}
+	authors := map[string]*Intervals{
+		"ana": {Wall: []float64{2.5, 52.5}, Working: []float64{2.5, 22.5}},
+		"rui": {Wall: []float64{119}, Working: []float64{26}}}
+	if !reflect.DeepEqual(a.Authors(), authors) {
+		t.Errorf("authors = %v, want %v", describe(a.Authors()), describe(authors))
+	}
+	issues := map[string]*Intervals{
+		"OFBIZ-1": {Wall: []float64{55, 65}, Working: []float64{25, 2}},
+		"OFBIZ-2": {}}
+	if !reflect.DeepEqual(a.Issues(), issues) {
+		t.Errorf("issues = %v, want %v", describe(a.Issues()), describe(issues))
+	}
+	if a.Heatmap[time.Wednesday][10] != 1 || a.Heatmap[time.Wednesday][12] != 1 ||
+		a.Heatmap[time.Friday][17] != 1 || a.Heatmap[time.Monday][10] != 1 {
+		t.Errorf("heatmap = %v", a.Heatmap)
+	}
+}
+
+func describe(intervals map[string]*Intervals) map[string]Intervals {
+	m := map[string]Intervals{}
+	for k, v := range intervals {
+		m[k] = *v
+	}
+	return m
+}
+
+func TestSummarize(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3, 10, 6, 7, 9, 8}
+	s := Summarize(values)
+	if s.N != 10 || s.Mean != 5.5 || s.Median != 5.5 || s.P90 != 9 {
+		t.Errorf("Summarize = %+v", s)
+	}
+	if values[0] != 5 || values[5] != 10 {
+		t.Errorf("Summarize sorted its argument: %v", values)
+	}
+	if s := Summarize(nil); s.N != 0 || !math.IsNaN(s.Mean) || !math.IsNaN(s.Median) || !math.IsNaN(s.P90) {
+		t.Errorf("Summarize(nil) = %+v, want NaNs", s)
+	}
+}
+
+func TestActivityOfSingleCommit(t *testing.T) {
+	a := NewActivityAnalyzer()
+	a.SessionGap = time.Hour
+	a.Add(&Commit{Issue: Issue{Id: "OFBIZ-1"}, Files: []string{"a", "b"},
+		Change: &Change{Author: "ana", ModifiedTime: time.Date(2012, 2, 15, 10, 0, 0, 0, time.UTC)}})
+	activity := a.Activity()
+	for name, v := range map[string]float64{"files per commit": activity.FilesPerCommit,
+		"hours between commits":  activity.HoursBetweenCommits,
+		"commits per session":    activity.CommitsPerSession,
+		"hours between sessions": activity.HoursBetweenSessions} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			t.Errorf("%v = %v", name, v)
+		}
+	}
+	if activity.FilesPerCommit != 2 || activity.Sessions != 1 || activity.CommitsPerSession != 1 {
+		t.Errorf("activity = %+v", activity)
+	}
+	if empty := NewActivityAnalyzer().Activity(); empty.FilesPerCommit != 0 || empty.HoursBetweenCommits != 0 {
+		t.Errorf("activity of no commits = %+v", empty)
+	}
+}